@@ -3,8 +3,11 @@ module github.com/iotaledger/iri-ls-sa-merger
 go 1.12
 
 require (
-	github.com/dgryski/go-metro v0.0.0-20180109044635-280f6062b5bc // indirect
+	github.com/facebookgo/ensure v0.0.0-20200202191622-63f1cf65ac4c // indirect
+	github.com/facebookgo/stack v0.0.0-20160209184415-751773369052 // indirect
+	github.com/facebookgo/subset v0.0.0-20200203212716-c811ad88dec4 // indirect
+	github.com/golang/snappy v0.0.1
 	github.com/iotaledger/iota.go v1.0.0-beta.7
-	github.com/seiflotfy/cuckoofilter v0.0.0-20190302225222-764cb5258d9b // indirect
+	github.com/pkg/errors v0.8.1
 	github.com/tecbot/gorocksdb v0.0.0-20190705090504-162552197222
 )
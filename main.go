@@ -4,26 +4,30 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/sha256"
-	"encoding/binary"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"math/big"
 	"os"
 	"path"
-	"strconv"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/iotaledger/iota.go/trinary"
-	"github.com/tecbot/gorocksdb"
+	"github.com/iotaledger/iri-ls-sa-merger/snapshot"
+	"github.com/iotaledger/iri-ls-sa-merger/storage"
+	"github.com/pkg/errors"
 )
 
-const bloomFilterBitsPerKey = 10
-const blockSizeDeviation = 10
-const blockRestartInterval = 16
-const blockCacheSize = 1000 * 1024
-const cacheNumShardBits = 2
+const exportFileBufferSize = 4 * 1024 * 1024
+const defaultBatchSize = 10000
+
+// sortRunSize is the amount of spent addresses of unsorted spent-addresses sources which are sorted at once in memory.
+const sortRunSize = 1000000
 
 var localSnapshotDBKey = func(num int32) []byte {
 	intAsByte := make([]byte, 4)
@@ -36,66 +40,238 @@ var localSnapshotDBKey = func(num int32) []byte {
 
 var spentAddrVal = []byte{}
 
+// network
+var networkName = flag.String("network", snapshot.NetworkMainnet, "the network profile (mainnet, devnet, comnet or custom) defining the expected supply, "+
+	"the default prefix of the local snapshot files and validation rules")
+var networkSupply = flag.Uint64("network-supply", 0, "the expected supply of the custom network profile, not checked if 0 (only valid with -network custom)")
+
+// network is the selected network profile.
+var network snapshot.Network
+
+// input validation
+var invalidTrytesPolicy = flag.String("invalid-trytes", "fail", "the policy for lines of text sources containing invalid hashes or addresses (fail or skip), "+
+	"addresses of 90 trytes are accepted if their checksum is valid")
+
+// onInvalidTrytes handles lines containing invalid hashes or addresses according to the selected policy, nil to fail.
+var onInvalidTrytes snapshot.InvalidTrytesHandler
+
+// checkpoints
+var checkpointsFileName = flag.String("checkpoints", "", "if set, the file of known-good index;hash milestone pairs local snapshots are checked against "+
+	"before they are verified, imported or written into a localsnapshots-db")
+
+// checkpoints are the known-good milestones of the checkpoint file, nil if none was defined.
+var checkpoints snapshot.Checkpoints
+
+// database writes
+var batchSize = flag.Int("batch-size", defaultBatchSize, "the amount of spent addresses written into a database per write batch")
+var disableWAL = flag.Bool("disable-wal", false, "if enabled, spent addresses are written into a database without the write-ahead log "+
+	"and flushed at the end, a crash before leaves the database incomplete")
+
 // merge local snapshot and spent addresses db
 var localSnapshotsDBTarget = flag.String("ls-db-dir", "./localsnapshots-db", "the name of the folder where the local snapshots database is written to")
 var spentAddrDbDir = flag.String("spent-addresses-db-dir", "./spent-addresses-db", "the name of the folder containing the spent addresses database")
-var lsStateFileName = flag.String("ls-state-file", "./mainnet.snapshot.state", "the name of the file containing the local snapshot state data")
-var lsMetaFileName = flag.String("ls-meta-file", "./mainnet.snapshot.meta", "the name of the file containing the local snapshot meta data")
+var lsStateFileName = flag.String("ls-state-file", "./mainnet.snapshot.state", "the name of the file containing the local snapshot state data, the prefix depends on the network")
+var lsMetaFileName = flag.String("ls-meta-file", "./mainnet.snapshot.meta", "the name of the file containing the local snapshot meta data, the prefix depends on the network")
 
 // export
-const expFileVersion byte = 4
-
 var genLSAddrExpFile = flag.Bool("export-db", false, "if enabled, exports all data from a local-snapshot/spent-addresses database into single binary file")
 var expFileName = flag.String("export-db-file", "export.bin", "the name of the binary file containing the exported database data")
 var printExpDbFileInfo = flag.Bool("export-db-file-info", false, "if enabled, simply prints the specified export file info to the console")
 var expOmitSpentAddrs = flag.Bool("omit-spent-addresses", false, "whether to omit exporting spent addresses")
+var expFileVersion = flag.Int("export-db-file-version", int(snapshot.ExportFileVersion), "the version of the written export file (4 or 5)")
+
+// convert export file
+var convertExpFile = flag.Bool("convert-export-db-file", false, "if enabled, converts the specified export file of any version into the current export file version")
+var convertExpFileTarget = flag.String("convert-export-db-file-target", "export.converted.bin", "the name of the file the converted export file is written to")
+
+// import export file
+var importExpFile = flag.Bool("import-export-db-file", false, "if enabled, rebuilds a localsnapshots-db (-ls-db-dir) from the specified v4 or later export file")
+
+// write local snapshot files
+var writeLSFiles = flag.Bool("write-ls-files", false, "if enabled, writes the local snapshot of the specified source into the -ls-meta-file and -ls-state-file files")
+var writeLSFilesSource = flag.String("write-ls-files-source", "./localsnapshots-db", "the localsnapshots-db folder or export file to write the local snapshot files from")
+var writeLSFilesSpentAddrDB = flag.String("write-ls-files-spent-addresses-db", "", "if set, additionally writes the spent addresses of the source into a new spent-addresses-db with the given folder name")
 
 // export spent address
 var genAddrExpFile = flag.Bool("export-spent-addr", false, "if enabled, exports all spent addresses from a local-snapshot/spent-addresses database database into single binary file")
 var addrExpFileName = flag.String("export-spent-addr-file", "spent_addresses.bin", "the name of the file containing the exported spent addresses")
+var printAddrExpFileInfo = flag.Bool("export-spent-addr-file-info", false, "if enabled, simply prints the specified spent addresses file info to the console and verifies its checksum")
 
 // merge spent addresses sources
 var mergeSpentAddr = flag.Bool("merge-spent-addresses", false, "if enabled, merges multiple source spent-addresses-db databases into one")
 var mergeSpentAddrSrcs = flag.String("merge-spent-addresses-sources", "", "the comma separated list of sources of spent-addresses to merge (can be RocksDB spent-addresses-db folders or/and "+
-	"text files i.e previousEpochsSpentAddresses.txt (needs to end in .txt)")
+	"text files i.e previousEpochsSpentAddresses.txt (needs to end in .txt) or/and spent addresses files i.e. spent_addresses.bin (needs to end in .bin)")
 var mergeSpentAddrTarget = flag.String("merge-spent-addresses-target", "./merged-spent-addresses-db", "the name of the folder containing the merged spent-addresses-dbs")
 
+// spent addresses set operations
+var spentAddrSetOp = flag.Bool("spent-addresses-set-op", false, "if enabled, applies a set operation to two spent-addresses sources")
+var spentAddrSetOpName = flag.String("spent-addresses-set-op-name", string(snapshot.SpentAddressesDifference), "the set operation to apply (union, difference, intersection or symmetric-difference)")
+var spentAddrSetOpSrcs = flag.String("spent-addresses-set-op-sources", "", "the comma separated two sources of spent-addresses (can be RocksDB spent-addresses-db folders, "+
+	"text files needing to end in .txt or spent addresses files needing to end in .bin), the difference yields the addresses of the first source missing in the second")
+var spentAddrSetOpTarget = flag.String("spent-addresses-set-op-target", "./spent-addresses-set-op.txt", "the name of the text file (needs to end in .txt) or the new spent-addresses-db folder the resulting addresses are written to")
+
+// verify
+var verifyLS = flag.Bool("verify", false, "if enabled, verifies the consistency of the local snapshot of the specified source and reports every violation as JSON")
+var verifySource = flag.String("verify-source", "", "the localsnapshots-db folder, export file or comma separated meta and state files to verify, "+
+	"the -ls-meta-file and -ls-state-file files if empty")
+var verifyReportFile = flag.String("verify-report", "", "the name of the file the JSON verification report is written to, stdout if empty")
+
+// audit supply
+var auditSupply = flag.Bool("audit-supply", false, "if enabled, compares the ledger of the specified source against a trusted reference and reports the supply difference and every differing balance")
+var auditSource = flag.String("audit-source", "", "the localsnapshots-db folder, export file or .state file to audit, the -ls-state-file file if empty")
+var auditReference = flag.String("audit-reference", "", "the trusted localsnapshots-db folder, export file or .state file to compare against")
+var auditReportFile = flag.String("audit-report", "", "if set, additionally writes the audit report as JSON into the file with the given name")
+
+// funded spent addresses
+var fundedSpentAddrs = flag.Bool("funded-spent-addresses", false, "if enabled, reports the addresses of the ledger of the specified source which have a balance but were already spent from")
+var fundedSpentAddrsSource = flag.String("funded-spent-addresses-source", "./localsnapshots-db", "the localsnapshots-db folder, export file, .state file or comma separated meta and state files whose ledger is checked")
+var fundedSpentAddrsSpentSource = flag.String("funded-spent-addresses-spent-source", "", "the spent-addresses-db folder, text file (needs to end in .txt) or spent addresses file (needs to end in .bin) "+
+	"to check against, the spent addresses of the localsnapshots-db folder or export file given by -funded-spent-addresses-source if empty")
+var fundedSpentAddrsReportFile = flag.String("funded-spent-addresses-report", "", "if set, additionally writes the report as JSON into the file with the given name")
+
+// diff
+var diffLS = flag.Bool("diff", false, "if enabled, reports the differences between the local snapshots of the two specified sources")
+var diffFrom = flag.String("diff-from", "", "the localsnapshots-db folder, export file or comma separated meta and state files to diff from")
+var diffTo = flag.String("diff-to", "", "the localsnapshots-db folder, export file or comma separated meta and state files to diff to")
+var diffFormat = flag.String("diff-format", "text", "the format of the diff (text or json)")
+var diffOutputFile = flag.String("diff-output", "", "the name of the file the diff is written to, stdout if empty")
+
 // meta
 var printLSFilesInfo = flag.Bool("ls-info", false, "if enabled, simply parses the specified local snapshot files and prints their info to the console")
 
-func must(err error) {
-	if err != nil {
-		panic(err)
-	}
-}
+// exit codes
+const (
+	exitCodeError = 1 + iota
+	exitCodeInvalidUsage
+	exitCodeFileNotFound
+	exitCodeUnsupportedVersion
+	exitCodeChecksumMismatch
+	exitCodeTruncated
+	exitCodeInvalidTrytes
+	exitCodeVerificationFailed
+	exitCodeMalformed
+	exitCodeCorrupted
+)
+
+var errInvalidUsage = errors.New("invalid usage")
+var errVerificationFailed = errors.New("verification failed")
 
 func main() {
 	flag.Parse()
 
-	fmt.Printf(">> IRI Localsnapshot & SpentAddresses Merger & Exporter v%d <<\n", expFileVersion)
+	fmt.Printf(">> IRI Localsnapshot & SpentAddresses Merger & Exporter v%d <<\n", snapshot.ExportFileVersion)
+
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode maps the given error to the exit code of the program.
+func exitCode(err error) int {
+	cause := errors.Cause(err)
+	switch cause.(type) {
+	case *snapshot.ErrTruncated:
+		return exitCodeTruncated
+	case *snapshot.ErrInvalidTrytes:
+		return exitCodeInvalidTrytes
+	case *snapshot.ErrMalformed:
+		return exitCodeMalformed
+	}
+	switch {
+	case cause == errInvalidUsage:
+		return exitCodeInvalidUsage
+	case cause == errVerificationFailed:
+		return exitCodeVerificationFailed
+	case cause == snapshot.ErrUnsupportedVersion:
+		return exitCodeUnsupportedVersion
+	case cause == snapshot.ErrChecksumMismatch:
+		return exitCodeChecksumMismatch
+	case cause == snapshot.ErrCorrupted:
+		return exitCodeCorrupted
+	case os.IsNotExist(cause):
+		return exitCodeFileNotFound
+	}
+	return exitCodeError
+}
+
+func run() error {
+	if err := selectNetwork(); err != nil {
+		return err
+	}
+	if err := selectInvalidTrytesPolicy(); err != nil {
+		return err
+	}
+	if err := readCheckpoints(); err != nil {
+		return err
+	}
+	if *batchSize <= 0 {
+		return errors.Wrapf(errInvalidUsage, "invalid batch size %d", *batchSize)
+	}
 
 	if *mergeSpentAddr {
 		fmt.Println("[merge spent-addresses sources mode]")
-		mergeSpentAddressesSources()
-		return
+		return mergeSpentAddressesSources()
+	}
+
+	if *spentAddrSetOp {
+		fmt.Println("[spent-addresses set operation mode]")
+		return applySpentAddressesSetOperation()
+	}
+
+	if *verifyLS {
+		fmt.Println("[verify local snapshot mode]")
+		return verifyLocalSnapshot()
+	}
+
+	if *auditSupply {
+		fmt.Println("[audit supply mode]")
+		return auditLedgerSupply()
+	}
+
+	if *fundedSpentAddrs {
+		fmt.Println("[funded spent addresses mode]")
+		return reportFundedSpentAddresses()
+	}
+
+	if *diffLS {
+		fmt.Println("[diff local snapshots mode]")
+		return diffLocalSnapshots()
 	}
 
 	if *printExpDbFileInfo {
 		fmt.Println("[print export file info mode]")
-		printExportFileInfo()
-		return
+		return printExportFileInfo()
+	}
+
+	if *printAddrExpFileInfo {
+		fmt.Println("[print spent addresses file info mode]")
+		return printSpentAddressesFileInfo()
+	}
+
+	if *convertExpFile {
+		fmt.Println("[convert export file mode]")
+		return convertExportFile()
+	}
+
+	if *importExpFile {
+		fmt.Println("[import export file into database mode]")
+		return importExportFile()
+	}
+
+	if *writeLSFiles {
+		fmt.Println("[write local snapshot files mode]")
+		return writeLocalSnapshotFiles()
 	}
 
 	if *genLSAddrExpFile {
 		fmt.Println("[generate local-snapshot+spent-addresses export file from database mode]")
-		generateExportFile()
-		return
+		return generateExportFile()
 	}
 
 	if *genAddrExpFile {
 		fmt.Println("[generate spent-addresses export file from database mode]")
-		generateSpentAddressesExportFile()
-		return
+		return generateSpentAddressesExportFile()
 	}
 
 	// delete folder
@@ -103,586 +279,1398 @@ func main() {
 
 	if *printLSFilesInfo {
 		fmt.Println("[print local snapshot files info mode]")
-		printLocalSnapshotFilesInfo(readLocalSnapshotFromFiles())
-		return
+		ls, err := readLocalSnapshotFromFiles()
+		if err != nil {
+			return err
+		}
+		printLocalSnapshotFilesInfo(ls)
+		return nil
 	}
 
 	fmt.Println("[merge local snapshot files and spent-addresses-db mode]")
-	spentAddrChan := make(chan []byte)
 	fmt.Println("reading and writing spent addresses database")
-	go readSpentAddressesDB(spentAddrChan, *spentAddrDbDir)
-	generateLocalSnapshotsDB(spentAddrChan)
+	return generateLocalSnapshotsDB()
 }
 
-func mergeSpentAddressesSources() {
+// selectNetwork selects the network profile defined by the flags
+// and derives the default names of the local snapshot files from it.
+func selectNetwork() error {
+	var err error
+	network, err = snapshot.NetworkByName(*networkName)
+	if err != nil {
+		return errors.Wrap(errInvalidUsage, err.Error())
+	}
+	if isFlagSet("network-supply") && network.Name != snapshot.NetworkCustom {
+		return errors.Wrapf(errInvalidUsage, "-network-supply only applies to the %s network, the supply of %s is predefined", snapshot.NetworkCustom, network.Name)
+	}
+	if network.Name == snapshot.NetworkCustom && *networkSupply != 0 {
+		network.Supply = *networkSupply
+		network.CheckSupply = true
+	}
+	setNetworkFileNames()
+	return nil
+}
+
+// selectInvalidTrytesPolicy selects how lines containing invalid hashes or addresses are handled.
+func selectInvalidTrytesPolicy() error {
+	switch *invalidTrytesPolicy {
+	case "fail":
+		onInvalidTrytes = nil
+	case "skip":
+		onInvalidTrytes = func(err *snapshot.ErrInvalidTrytes) error {
+			fmt.Printf("skipping line: %v\n", err)
+			return nil
+		}
+	default:
+		return errors.Wrapf(errInvalidUsage, "unknown invalid trytes policy %s, expected fail or skip", *invalidTrytesPolicy)
+	}
+	return nil
+}
+
+// readCheckpoints reads the checkpoint file if one was defined.
+func readCheckpoints() error {
+	if *checkpointsFileName == "" {
+		return nil
+	}
+	file, err := os.OpenFile(*checkpointsFileName, os.O_RDONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	checkpoints, err = snapshot.ReadCheckpoints(file, snapshot.TextOptions{Source: *checkpointsFileName, OnInvalidTrytes: onInvalidTrytes})
+	if err != nil {
+		return errors.Wrap(err, "could not read checkpoint file")
+	}
+	fmt.Printf("read %d checkpoints from %s\n", len(checkpoints), *checkpointsFileName)
+	return nil
+}
+
+// verifyCheckpoints checks the given local snapshot against the checkpoints, if any were defined,
+// and prints every violation.
+func verifyCheckpoints(ls *snapshot.Snapshot) error {
+	if checkpoints == nil {
+		return nil
+	}
+	violations, matches := snapshot.VerifyCheckpoints(ls, checkpoints)
+	for _, v := range violations {
+		fmt.Printf("%s %s: %s\n", v.Section, v.Hash, v.Message)
+	}
+	if len(violations) > 0 {
+		return errors.Wrapf(errVerificationFailed, "the local snapshot does not match %d checkpoints", len(violations))
+	}
+	fmt.Printf("%d milestones and solid entry points match the checkpoints\n", matches)
+	return nil
+}
+
+// setNetworkFileNames derives the names of the local snapshot files from the selected network unless they were set explicitly.
+func setNetworkFileNames() {
+	if !isFlagSet("ls-meta-file") {
+		*lsMetaFileName = fmt.Sprintf("./%s.snapshot.meta", network.FilePrefix)
+	}
+	if !isFlagSet("ls-state-file") {
+		*lsStateFileName = fmt.Sprintf("./%s.snapshot.state", network.FilePrefix)
+	}
+}
+
+// useExportNetwork makes sure the network recorded in the given export file header matches the selected network.
+// If no network was selected explicitly, the recorded network is selected instead, unless it is the custom network.
+func useExportNetwork(header *snapshot.ExportHeader) error {
+	if header.NetworkID == "" || header.NetworkID == network.Name {
+		return nil
+	}
+	if isFlagSet("network") {
+		return errors.Wrapf(errInvalidUsage, "the export file belongs to network %s but network %s was selected", header.NetworkID, network.Name)
+	}
+	recorded, err := snapshot.NetworkByName(header.NetworkID)
+	if err != nil {
+		return errors.Wrap(errInvalidUsage, err.Error())
+	}
+	// the supply of a custom network is not recorded, switching to it would silently skip the supply check
+	if recorded.Name == snapshot.NetworkCustom {
+		return errors.Wrapf(errInvalidUsage, "the export file belongs to the %s network, whose supply is not recorded: select it via -network %s "+
+			"and define its supply via -network-supply", snapshot.NetworkCustom, snapshot.NetworkCustom)
+	}
+	fmt.Printf("using network %s recorded in the export file\n", recorded.Name)
+	network = recorded
+	setNetworkFileNames()
+	return nil
+}
+
+// isFlagSet checks whether the flag with the given name was set on the command line.
+func isFlagSet(name string) bool {
+	var set bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func mergeSpentAddressesSources() error {
 	s := time.Now()
 	sources := strings.Split(*mergeSpentAddrSrcs, ",")
 	if len(sources) < 2 {
-		panic("you must define at least 2 spent-addresses sources")
+		return errors.Wrap(errInvalidUsage, "you must define at least 2 spent-addresses sources")
+	}
+
+	store, err := openStore(*mergeSpentAddrTarget, storage.SpentAddressesDBColumnFamilies)
+	if err != nil {
+		return errors.Wrapf(err, "could not open target database %s", *mergeSpentAddrTarget)
 	}
+	defer store.Close()
 
-	cfOpt := gorocksdb.NewDefaultOptions()
-	cfOpts := []*gorocksdb.Options{cfOpt, cfOpt}
+	// unsorted sources are sorted in runs within a temporary folder next to the target database
+	var sortDir string
+	defer func() {
+		if sortDir != "" {
+			os.RemoveAll(sortDir)
+		}
+	}()
+
+	// every source is passed as one or more sorted streams to the merge
+	var streams []func(fn func(addr []byte) error) error
+	var streamSources []int
+	read := make([]int, len(sources))
+	externallySorted := make([]bool, len(sources))
+	for i, source := range sources {
+		sourceStreams, sourceRead, sorted, err := sortedSpentAddressesSource(source, *mergeSpentAddrTarget, &sortDir)
+		if err != nil {
+			return err
+		}
+		read[i], externallySorted[i] = sourceRead, sorted
+		for _, stream := range sourceStreams {
+			streams = append(streams, stream)
+			streamSources = append(streamSources, i)
+		}
+	}
+
+	fmt.Println("merging...")
+	ws := time.Now()
+	var counts []snapshot.SpentAddressesMergeCount
+	count, err := putSortedSpentAddresses(store, func(fn func(addr []byte) error) error {
+		var mergeErr error
+		counts, mergeErr = snapshot.MergeSpentAddresses(streams, fn)
+		return mergeErr
+	})
+	if err != nil {
+		return err
+	}
+
+	added := make([]int, len(sources))
+	for i, c := range counts {
+		added[streamSources[i]] += c.Added
+		// the runs of a sorted source lack its duplicates, its addresses were counted while sorting
+		if !externallySorted[streamSources[i]] {
+			read[streamSources[i]] += c.Read
+		}
+	}
+	for i, source := range sources {
+		fmt.Printf("%s: new %d, known %d\n", source, added[i], read[i]-added[i])
+	}
+
+	fmt.Printf("persisted %d spent addresses (%.0f addresses/s)\n", count, addressesPerSecond(count, ws))
+	fmt.Printf("finished, took %v\n", time.Now().Sub(s))
+	return nil
+}
+
+// sortedSpentAddressesSource returns the spent addresses of the given source as one or more streams, each passing
+// them in strictly ascending byte order. Text files and unsorted spent addresses files are sorted in runs within a temporary
+// folder next to the given target, which is created on demand, stored in sortDir and removed by the caller.
+// externallySorted reports whether the source was sorted, read is the amount of spent addresses read while doing so.
+func sortedSpentAddressesSource(source string, target string, sortDir *string) (streams []func(fn func(addr []byte) error) error, read int, externallySorted bool, err error) {
+	fmt.Printf("reading in %s\n", source)
+	sorted := true
+	switch path.Ext(source) {
+	case ".txt":
+		sorted = false
+	case ".bin":
+		if sorted, err = spentAddressesFileSorted(source); err != nil {
+			return nil, 0, false, err
+		}
+	}
+	if sorted {
+		return []func(fn func(addr []byte) error) error{func(fn func(addr []byte) error) error {
+			return readSpentAddressesSource(source, fn)
+		}}, 0, false, nil
+	}
+
+	if *sortDir == "" {
+		target = filepath.Clean(target)
+		if *sortDir, err = ioutil.TempDir(filepath.Dir(target), filepath.Base(target)+".sort-"); err != nil {
+			return nil, 0, false, err
+		}
+	}
+	fmt.Printf("sorting %s...\n", source)
+	sorter := snapshot.NewSpentAddressesSorter(*sortDir, sortRunSize)
+	if err := readSpentAddressesSource(source, func(spentAddrBytes []byte) error {
+		read++
+		return sorter.Add(spentAddrBytes)
+	}); err != nil {
+		return nil, 0, false, err
+	}
+	if streams, err = sorter.Runs(); err != nil {
+		return nil, 0, false, err
+	}
+	return streams, read, true, nil
+}
 
-	db, cfs, err := gorocksdb.OpenDbColumnFamilies(defaultOpts(), *mergeSpentAddrTarget, []string{"default", "spent-addresses"}, cfOpts)
-	must(err)
-	defer db.Close()
+func applySpentAddressesSetOperation() error {
+	s := time.Now()
+	op, err := snapshot.ParseSpentAddressesSetOperation(*spentAddrSetOpName)
+	if err != nil {
+		return errors.Wrap(errInvalidUsage, err.Error())
+	}
+	sources := strings.Split(*spentAddrSetOpSrcs, ",")
+	if len(sources) != 2 {
+		return errors.Wrap(errInvalidUsage, "you must define exactly 2 spent-addresses sources")
+	}
+	if _, err := os.Stat(*spentAddrSetOpTarget); err == nil {
+		return errors.Wrapf(errInvalidUsage, "target %s already exists", *spentAddrSetOpTarget)
+	}
 
-	wo := gorocksdb.NewDefaultWriteOptions()
-	defer wo.Destroy()
+	// unsorted sources are sorted in runs within a temporary folder next to the target
+	var sortDir string
+	defer func() {
+		if sortDir != "" {
+			os.RemoveAll(sortDir)
+		}
+	}()
+
+	// the runs of a sorted source are merged into a single stream, which the set operation walks side by side with the other one
+	sourceStreams := make([]func(fn func(addr []byte) error) error, len(sources))
+	for i, source := range sources {
+		streams, _, _, err := sortedSpentAddressesSource(source, *spentAddrSetOpTarget, &sortDir)
+		if err != nil {
+			return err
+		}
+		sourceStreams[i] = streams[0]
+		if len(streams) != 1 {
+			sourceStreams[i] = func(fn func(addr []byte) error) error {
+				_, err := snapshot.MergeSpentAddresses(streams, fn)
+				return err
+			}
+		}
+	}
 
-	filter := map[string]struct{}{}
+	var counts [2]int
+	stream := func(fn func(addr []byte) error) error {
+		var setErr error
+		counts, setErr = snapshot.ApplySpentAddressesSetOperation(op, sourceStreams[0], sourceStreams[1], fn)
+		return setErr
+	}
 
 	var count int
-	var known int
-	for _, source := range sources {
-		fmt.Printf("reading in %s\n", source)
-		var addrsCount int
-		var added int
-		if path.Ext(source) == ".txt" {
-			f, err := os.OpenFile(source, os.O_RDONLY, 066)
-			must(err)
-			scanner := bufio.NewScanner(f)
-			for scanner.Scan() {
-				addrsCount++
-				spentAddrTrytes := scanner.Text()
-				spentAddrBytes, err := trinary.TrytesToBytes(spentAddrTrytes)
-				must(err)
-				filterKey := fmt.Sprintf("%x", sha256.Sum256(spentAddrBytes))
-				if _, has := filter[filterKey]; has {
-					fmt.Printf("new %d, known %d \t\r", added, known)
-					known++
-					continue
-				}
-				must(db.PutCF(wo, cfs[1], spentAddrBytes, spentAddrVal))
-				filter[filterKey] = struct{}{}
-				added++
-				fmt.Printf("new %d, known %d \t\r", added, known)
+	if path.Ext(*spentAddrSetOpTarget) == ".txt" {
+		file, err := os.OpenFile(*spentAddrSetOpTarget, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if count, err = snapshot.WriteSpentAddressesText(file, stream); err != nil {
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	} else {
+		store, err := openStore(*spentAddrSetOpTarget, storage.SpentAddressesDBColumnFamilies)
+		if err != nil {
+			return errors.Wrapf(err, "could not open target database %s", *spentAddrSetOpTarget)
+		}
+		defer store.Close()
+		if count, err = putSortedSpentAddresses(store, stream); err != nil {
+			return err
+		}
+	}
+
+	for i, source := range sources {
+		fmt.Printf("%s contains %d distinct spent addresses\n", source, counts[i])
+	}
+	fmt.Printf("wrote %d spent addresses of the %s of %s and %s to %s\n", count, op, sources[0], sources[1], *spentAddrSetOpTarget)
+	fmt.Printf("finished, took %v\n", time.Now().Sub(s))
+	return nil
+}
+
+func printExportFileInfo() error {
+	var header *snapshot.ExportHeader
+	var total uint64
+	var overflow bool
+	var cuckooFilterSize int32
+	hash, err := streamExportFile(*expFileName, snapshot.ExportConsumer{
+		Header: func(h *snapshot.ExportHeader) error {
+			header = h
+			return useExportNetwork(h)
+		},
+		LedgerEntry: func(_ trinary.Hash, balance uint64) error {
+			if balance > math.MaxUint64-total {
+				overflow = true
 			}
-			must(f.Close())
+			total += balance
+			return nil
+		},
+		CuckooFilter: func(size int32, _ io.Reader) error {
+			cuckooFilterSize = size
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var bytesRead int
+	if header.Sections != nil {
+		// sections and their checksums
+		for _, section := range header.Sections {
+			bytesRead += int(section.ByteLength) + sha256.Size
+		}
+	} else {
+		// milestone hash and counters
+		bytesRead = snapshot.HashBytesSize + 28
+
+		// hash based data
+		bytesRead += int(header.SolidEntryPointsCount) * (snapshot.HashBytesSize + 4)
+		bytesRead += int(header.SeenMilestonesCount) * (snapshot.HashBytesSize + 4)
+		bytesRead += int(header.LedgerEntriesCount) * (snapshot.HashBytesSize + 8)
+		if header.HasCuckooFilter() {
+			bytesRead += 4 + int(cuckooFilterSize)
 		} else {
-			in := make(chan []byte)
-			go readSpentAddressesDB(in, source)
-
-			for spentAddrBytes := range in {
-				addrsCount++
-				must(err)
-				filterKey := fmt.Sprintf("%x", sha256.Sum256(spentAddrBytes))
-				if _, has := filter[filterKey]; has {
-					known++
-					fmt.Printf("new %d, known %d \t\r", added, known)
-					continue
+			bytesRead += int(header.SpentAddressesCount) * snapshot.HashBytesSize
+		}
+	}
+
+	fmt.Println("file version:", header.Version)
+	fmt.Println("gzip compressed:", header.Compressed)
+	if header.Version >= 5 {
+		fmt.Println("network:", header.NetworkID)
+		for _, section := range header.Sections {
+			fmt.Printf("section %s: %d entries, %d bytes\n", section.Type, section.Count, section.ByteLength)
+		}
+	}
+	fmt.Println("read following local snapshot from the exported database file:")
+	fmt.Printf("ms index/hash/timestamp: %d/%s/%d\nsolid entry points: %d\nseen milestones: %d\nledger entries: %d\n",
+		header.MilestoneIndex, header.MilestoneHash, header.MilestoneTimestamp,
+		header.SolidEntryPointsCount, header.SeenMilestonesCount, header.LedgerEntriesCount)
+	printSupplyCheck(total, overflow)
+	fmt.Printf("size: %d KBs\n", header.SnapshotSizeInBytes()/1024)
+	if header.HasCuckooFilter() {
+		fmt.Printf("spent addresses cuckoo filter size: %d KBs\n", cuckooFilterSize/1024)
+		fmt.Printf("contains %d spent addresses in the cuckoo filter\n", header.SpentAddressesCount)
+	} else {
+		fmt.Printf("contains %d spent addresses\n", header.SpentAddressesCount)
+	}
+
+	fmt.Printf("read a total of %d KBs\n", bytesRead/1024)
+	if !header.HasChecksum() {
+		fmt.Printf("file version %d contains no checksum, data integrity can not be checked\n", header.Version)
+		return nil
+	}
+	fmt.Printf("data integrity check successful (sha256): %x\n", hash)
+	return nil
+}
+
+func convertExportFile() error {
+	s := time.Now()
+
+	expOpts, err := exportOptions()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("reading export file %s...\n", *expFileName)
+	file, err := os.OpenFile(*expFileName, os.O_RDONLY, 0666)
+	if err != nil {
+		return err
+	}
+	exp, err := snapshot.ReadExport(bufio.NewReader(file), snapshot.ReadExportOptions{SkipSpentAddresses: true})
+	file.Close()
+	if err != nil {
+		return errors.Wrapf(err, "could not read export file %s", *expFileName)
+	}
+	fmt.Printf("read export file version %d\n", exp.Header.Version)
+	if err := useExportNetwork(exp.Header); err != nil {
+		return err
+	}
+	expOpts.NetworkID = network.Name
+
+	switch {
+	case *expOmitSpentAddrs:
+		fmt.Println("omitting spent addresses in converted export file")
+	case exp.Header.HasCuckooFilter():
+		return errors.Wrapf(errInvalidUsage, "file version %d contains the spent addresses as cuckoo filter which can not be converted, "+
+			"use -omit-spent-addresses", exp.Header.Version)
+	default:
+		sorted, err := exportFileSpentAddressesSorted(*expFileName)
+		if err != nil {
+			return err
+		}
+		if sorted {
+			expOpts.SpentAddressesCount = exp.Header.SpentAddressesCount
+			expOpts.SpentAddresses = func(fn func(addr []byte) error) error {
+				// stream the spent addresses in a second pass over the source file
+				_, err := streamExportFile(*expFileName, snapshot.ExportConsumer{SpentAddress: fn})
+				return err
+			}
+			break
+		}
+
+		// files written before the output was sorted need to be sorted in memory
+		fmt.Println("sorting spent addresses...")
+		spentAddrs := make([][]byte, 0, exp.Header.SpentAddressesCount)
+		if _, err := streamExportFile(*expFileName, snapshot.ExportConsumer{SpentAddress: func(addr []byte) error {
+			spentAddrs = append(spentAddrs, append([]byte(nil), addr...))
+			return nil
+		}}); err != nil {
+			return err
+		}
+		spentAddrs = snapshot.SortSpentAddresses(spentAddrs)
+		expOpts.SpentAddressesCount = int32(len(spentAddrs))
+		expOpts.SpentAddresses = func(fn func(addr []byte) error) error {
+			for _, addr := range spentAddrs {
+				if err := fn(addr); err != nil {
+					return err
 				}
-				must(db.PutCF(wo, cfs[1], spentAddrBytes, spentAddrVal))
-				filter[filterKey] = struct{}{}
-				added++
-				fmt.Printf("new %d, known %d \t\r", added, known)
 			}
+			return nil
 		}
+	}
 
-		fmt.Printf("new %d, known %d ...done\t\n", added, known)
-		known = 0
-		count += added
+	fmt.Printf("writing version %d export file %s\n", expOpts.Version, *convertExpFileTarget)
+	os.Remove(*convertExpFileTarget)
+	targetFile, err := os.OpenFile(*convertExpFileTarget, os.O_WRONLY|os.O_CREATE, 0660)
+	if err != nil {
+		return err
 	}
+	defer targetFile.Close()
+	w := bufio.NewWriterSize(targetFile, exportFileBufferSize)
 
-	fmt.Printf("persisted %d spent addresses\n", count)
+	sha256Hash, err := snapshot.WriteExport(w, exp.Snapshot, expOpts)
+	if err != nil {
+		return errors.Wrapf(err, "could not write export file %s", *convertExpFileTarget)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := targetFile.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("sha256: %x\n", sha256Hash)
 	fmt.Printf("finished, took %v\n", time.Now().Sub(s))
+	return nil
 }
 
-func printExportFileInfo() {
-	file, err := os.OpenFile(*expFileName, os.O_RDONLY, 0666)
-	must(err)
-	defer file.Close()
+// exportOptions returns the export options defined by the flags.
+func exportOptions() (snapshot.ExportOptions, error) {
+	if *expFileVersion != 4 && *expFileVersion != int(snapshot.ExportFileVersion) {
+		return snapshot.ExportOptions{}, errors.Wrapf(errInvalidUsage, "export file version %d can not be written, only versions 4 and %d",
+			*expFileVersion, snapshot.ExportFileVersion)
+	}
+	return snapshot.ExportOptions{Version: byte(*expFileVersion), NetworkID: network.Name}, nil
+}
 
-	var bytesRead int
+func importExportFile() error {
+	s := time.Now()
+
+	if _, err := os.Stat(*localSnapshotsDBTarget); err == nil {
+		return errors.Wrapf(errInvalidUsage, "target database %s already exists", *localSnapshotsDBTarget)
+	}
 
-	var fileVersion byte
-	must(binary.Read(file, binary.LittleEndian, &fileVersion))
+	// the first pass verifies the checksum before anything is written
+	fmt.Printf("reading and verifying export file %s...\n", *expFileName)
+	file, err := os.OpenFile(*expFileName, os.O_RDONLY, 0666)
+	if err != nil {
+		return err
+	}
+	exp, err := snapshot.ReadExport(bufio.NewReader(file), snapshot.ReadExportOptions{SkipSpentAddresses: true})
+	file.Close()
+	if err != nil {
+		return errors.Wrapf(err, "could not read export file %s", *expFileName)
+	}
+	if exp.Header.Version < 4 {
+		return errors.Wrapf(snapshot.ErrUnsupportedVersion, "file version %d can not be imported, only version 4 and later", exp.Header.Version)
+	}
+	if err := useExportNetwork(exp.Header); err != nil {
+		return err
+	}
+	fmt.Printf("read export file version %d, sha256: %x\n", exp.Header.Version, exp.Hash)
+	printLocalSnapshotFilesInfo(exp.Snapshot)
+	if err := verifyCheckpoints(exp.Snapshot); err != nil {
+		return err
+	}
 
-	if fileVersion != expFileVersion {
-		panic(fmt.Sprintf("file version %d is not supported, only version %d", fileVersion, expFileVersion))
+	store, err := openStore(*localSnapshotsDBTarget, storage.LocalSnapshotsDBColumnFamilies)
+	if err != nil {
+		return errors.Wrapf(err, "could not open target database %s", *localSnapshotsDBTarget)
 	}
+	defer store.Close()
 
-	ls := &localsnapshot{
-		solidEntryPoints: make(map[string]int32),
-		seenMilestones:   make(map[string]int32),
-		ledgerState:      make(map[string]uint64),
+	fmt.Println("writing spent addresses...")
+	ws := time.Now()
+	count, err := putSortedSpentAddresses(store, func(fn func(addr []byte) error) error {
+		_, err := streamExportFile(*expFileName, snapshot.ExportConsumer{SpentAddress: fn})
+		return err
+	})
+	if err != nil {
+		return err
 	}
+	fmt.Printf("persisted %d spent addresses (%.0f addresses/s)\n", count, addressesPerSecond(count, ws))
 
-	// read in milestone hash
-	hashBuf := make([]byte, 49)
-	_, err = file.Read(hashBuf)
-	must(err)
-	bytesRead += 49
+	// the local snapshot is written last, so an aborted import leaves no usable database behind
+	fmt.Println("writing local snapshot data...")
+	if err := putLocalSnapshot(store, exp.Snapshot); err != nil {
+		return err
+	}
 
-	lsMsHash, err := trinary.BytesToTrytes(hashBuf)
-	must(err)
-	ls.msHash = lsMsHash[:81]
-	var solidEntryPointsCount, seenMilestonesCount, ledgerEntriesCount, spentAddrsCount int32
-	must(binary.Read(file, binary.LittleEndian, &ls.msIndex))
-	must(binary.Read(file, binary.LittleEndian, &ls.msTimestamp))
-	must(binary.Read(file, binary.LittleEndian, &solidEntryPointsCount))
-	must(binary.Read(file, binary.LittleEndian, &seenMilestonesCount))
-	must(binary.Read(file, binary.LittleEndian, &ledgerEntriesCount))
-	must(binary.Read(file, binary.LittleEndian, &spentAddrsCount))
+	fmt.Printf("finished, took %v\n", time.Now().Sub(s))
+	return nil
+}
 
-	// counters
-	bytesRead += 28
+// putSpentAddressesBatched writes the spent addresses passed by the given stream function into the spent-addresses column family
+// of the given store using write batches of the size and with the write-ahead log setting defined by the flags
+// and flushes the store afterwards. It returns the amount of written spent addresses.
+func putSpentAddressesBatched(store storage.Store, stream func(fn func(addr []byte) error) error) (int, error) {
+	batch := store.NewBatch(storage.WriteOptions{DisableWAL: *disableWAL})
+	defer batch.Close()
 
-	// hash based data
-	bytesRead += int(solidEntryPointsCount) * (49 + 4)
-	bytesRead += int(seenMilestonesCount) * (49 + 4)
-	bytesRead += int(ledgerEntriesCount) * (49 + 8)
-	bytesRead += int(spentAddrsCount) * (49)
+	var count int
+	if err := stream(func(addr []byte) error {
+		batch.Put(storage.ColumnFamilySpentAddresses, addr, spentAddrVal)
+		count++
+		if batch.Count() < *batchSize {
+			return nil
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		fmt.Printf("%d\t\r", count)
+		return nil
+	}); err != nil {
+		return count, err
+	}
+	if err := batch.Write(); err != nil {
+		return count, err
+	}
+	return count, store.Flush()
+}
 
-	for i := 0; i < int(solidEntryPointsCount); i++ {
-		var val int32
-		must(binary.Read(file, binary.LittleEndian, hashBuf))
-		must(binary.Read(file, binary.LittleEndian, &val))
-		hash, err := trinary.BytesToTrytes(hashBuf)
-		must(err)
-		ls.solidEntryPoints[hash[:81]] = val
+// putSortedSpentAddresses writes the spent addresses passed by the given stream function into the spent-addresses column family
+// of the given store. As long as the addresses are passed in ascending order and the store supports it, they are written
+// into table files which are added to the database as a whole, bypassing the write-ahead log and memtables.
+// Repetitions of the previous address are skipped, the addresses following the first one smaller than its
+// predecessor are written like putSpentAddressesBatched does.
+// It returns the amount of written spent addresses.
+func putSortedSpentAddresses(store storage.Store, stream func(fn func(addr []byte) error) error) (int, error) {
+	loader, ok := store.(storage.BulkLoader)
+	if !ok {
+		return putSpentAddressesBatched(store, stream)
 	}
+	w, err := loader.NewSortedWriter(storage.ColumnFamilySpentAddresses)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Close()
 
-	for i := 0; i < int(seenMilestonesCount); i++ {
-		var val int32
-		must(binary.Read(file, binary.LittleEndian, hashBuf))
-		must(binary.Read(file, binary.LittleEndian, &val))
-		hash, err := trinary.BytesToTrytes(hashBuf)
-		must(err)
-		ls.seenMilestones[hash[:81]] = val
+	var count int
+	var last []byte
+	var batch storage.Batch
+	defer func() {
+		if batch != nil {
+			batch.Close()
+		}
+	}()
+	if err := stream(func(addr []byte) error {
+		if batch == nil && last != nil {
+			cmp := bytes.Compare(last, addr)
+			if cmp == 0 {
+				return nil
+			}
+			if cmp > 0 {
+				// the addresses written so far are kept
+				fmt.Printf("spent addresses not sorted after %d, writing the remaining ones in batches\n", count)
+				if err := w.Finish(); err != nil {
+					return err
+				}
+				batch = store.NewBatch(storage.WriteOptions{DisableWAL: *disableWAL})
+			}
+		}
+		count++
+		if batch != nil {
+			batch.Put(storage.ColumnFamilySpentAddresses, addr, spentAddrVal)
+			if batch.Count() < *batchSize {
+				return nil
+			}
+			if err := batch.Write(); err != nil {
+				return err
+			}
+		} else {
+			if err := w.Put(addr, spentAddrVal); err != nil {
+				return err
+			}
+			last = append(last[:0], addr...)
+			if count%*batchSize != 0 {
+				return nil
+			}
+		}
+		fmt.Printf("%d\t\r", count)
+		return nil
+	}); err != nil {
+		return count, err
+	}
+	if batch == nil {
+		return count, w.Finish()
 	}
+	if err := batch.Write(); err != nil {
+		return count, err
+	}
+	return count, store.Flush()
+}
 
-	for i := 0; i < int(ledgerEntriesCount); i++ {
-		var val uint64
-		must(binary.Read(file, binary.LittleEndian, hashBuf))
-		must(binary.Read(file, binary.LittleEndian, &val))
-		hash, err := trinary.BytesToTrytes(hashBuf)
-		must(err)
-		ls.ledgerState[hash[:81]] = val
+// addressesPerSecond returns the throughput of writing the given amount of spent addresses since the given time.
+func addressesPerSecond(count int, since time.Time) float64 {
+	elapsed := time.Since(since).Seconds()
+	if elapsed == 0 {
+		return 0
 	}
+	return float64(count) / elapsed
+}
+
+func writeLocalSnapshotFiles() error {
+	s := time.Now()
 
-	for i := 0; i < int(spentAddrsCount); i++ {
-		must(binary.Read(file, binary.LittleEndian, hashBuf))
+	source := *writeLSFilesSource
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("file version:", fileVersion)
-	fmt.Println("read following local snapshot from the exported database file:")
+	var ls *snapshot.Snapshot
+	var spentAddrs func(fn func(addr []byte) error) error
+	if info.IsDir() {
+		fmt.Printf("reading local snapshot from database %s...\n", source)
+		store, err := openStoreReadOnly(source, storage.LocalSnapshotsDBColumnFamilies)
+		if err != nil {
+			return errors.Wrapf(err, "could not open database %s", source)
+		}
+		defer store.Close()
+
+		ls, err = readLocalSnapshotFromDB(store)
+		if err != nil {
+			return err
+		}
+		if ls == nil {
+			return errors.Errorf("no local snapshot in %s persisted", source)
+		}
+		spentAddrs = func(fn func(addr []byte) error) error {
+			return forEachKey(store, storage.ColumnFamilySpentAddresses, fn)
+		}
+	} else {
+		fmt.Printf("reading local snapshot from export file %s...\n", source)
+		file, err := os.OpenFile(source, os.O_RDONLY, 0666)
+		if err != nil {
+			return err
+		}
+		exp, err := snapshot.ReadExport(bufio.NewReader(file), snapshot.ReadExportOptions{SkipSpentAddresses: true})
+		file.Close()
+		if err != nil {
+			return errors.Wrapf(err, "could not read export file %s", source)
+		}
+		if err := useExportNetwork(exp.Header); err != nil {
+			return err
+		}
+		if exp.Header.HasCuckooFilter() && *writeLSFilesSpentAddrDB != "" {
+			return errors.Wrapf(errInvalidUsage, "file version %d contains the spent addresses as cuckoo filter which can not be written into a database",
+				exp.Header.Version)
+		}
+		ls = exp.Snapshot
+		spentAddrs = func(fn func(addr []byte) error) error {
+			_, err := streamExportFile(source, snapshot.ExportConsumer{SpentAddress: fn})
+			return err
+		}
+	}
 	printLocalSnapshotFilesInfo(ls)
-	fmt.Printf("contains %d spent addresses\n", spentAddrsCount)
 
-	fmt.Printf("read a total of %d KBs\n", bytesRead/1024)
-	hashInFile := make([]byte, 32)
-	must(binary.Read(file, binary.LittleEndian, hashInFile))
+	fmt.Printf("writing local snapshot files %s and %s\n", *lsMetaFileName, *lsStateFileName)
+	if err := snapshot.WriteToFiles(snapshot.FilesOptions{MetaFile: *lsMetaFileName, StateFile: *lsStateFileName}, ls); err != nil {
+		return errors.Wrap(err, "could not write local snapshot files")
+	}
 
-	// read in sha256 hash
-	_, err = file.Seek(0, 0)
-	must(err)
+	if *writeLSFilesSpentAddrDB != "" {
+		fmt.Printf("writing spent addresses into %s...\n", *writeLSFilesSpentAddrDB)
+		store, err := openStore(*writeLSFilesSpentAddrDB, storage.SpentAddressesDBColumnFamilies)
+		if err != nil {
+			return errors.Wrapf(err, "could not open target database %s", *writeLSFilesSpentAddrDB)
+		}
+		defer store.Close()
 
-	// re read file to compute sha256 hash
-	fBytes, err := ioutil.ReadAll(file)
+		ws := time.Now()
+		count, err := putSortedSpentAddresses(store, spentAddrs)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("persisted %d spent addresses (%.0f addresses/s)\n", count, addressesPerSecond(count, ws))
+	}
+
+	fmt.Printf("finished, took %v\n", time.Now().Sub(s))
+	return nil
+}
+
+func verifyLocalSnapshot() error {
+	source := *verifySource
+	if source == "" {
+		source = fmt.Sprintf("%s,%s", *lsMetaFileName, *lsStateFileName)
+	}
+	ls, declared, err := readLocalSnapshotWithCounts(source)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	// trim file hash
-	fBytes = fBytes[:len(fBytes)-32]
-	computedHash := sha256.Sum256(fBytes)
-	if !bytes.Equal(hashInFile, computedHash[:]) {
-		panic(fmt.Sprintf("computed and sha256 hash do not match: %x (file) vs. %x (computed)", hashInFile, computedHash))
+	report := snapshot.Verify(ls, snapshot.VerifyOptions{Network: network, Declared: declared, Checkpoints: checkpoints})
+	report.Source = source
+
+	reportJSON, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if *verifyReportFile == "" {
+		fmt.Println(string(reportJSON))
+	} else {
+		if err := ioutil.WriteFile(*verifyReportFile, append(reportJSON, '\n'), 0660); err != nil {
+			return err
+		}
+		fmt.Printf("wrote verification report to %s\n", *verifyReportFile)
 	}
-	fmt.Printf("data integrity check successful (sha256): %x\n", computedHash)
+
+	if !report.Valid {
+		return errors.Wrapf(errVerificationFailed, "found %d violations in %s", len(report.Violations), source)
+	}
+	fmt.Printf("verification of %s successful\n", source)
+	return nil
 }
 
-func generateSpentAddressesExportFile() {
-	s := time.Now()
+func auditLedgerSupply() error {
+	if *auditReference == "" {
+		return errors.Wrap(errInvalidUsage, "you must define a reference to audit against")
+	}
 
-	cfOpt := gorocksdb.NewDefaultOptions()
-	cfOpts := []*gorocksdb.Options{cfOpt, cfOpt, cfOpt}
+	source := *auditSource
+	if source == "" {
+		source = *lsStateFileName
+	}
+	fmt.Printf("reading ledger of %s...\n", source)
+	ledger, err := readLedger(source)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("reading reference ledger of %s...\n", *auditReference)
+	reference, err := readLedger(*auditReference)
+	if err != nil {
+		return err
+	}
 
-	db, cfs, err := gorocksdb.OpenDbColumnFamilies(defaultOpts(), *localSnapshotsDBTarget, []string{"default", "spent-addresses", "localsnapshots"}, cfOpts)
-	must(err)
-	defer db.Close()
-	ro := gorocksdb.NewDefaultReadOptions()
+	report := snapshot.AuditLedger(ledger, reference)
+	report.Source = source
+	report.Reference = *auditReference
+
+	fmt.Printf("supply: %s (expected %d on network %s)\n", report.Supply, network.Supply, network.Name)
+	fmt.Printf("reference supply: %s\n", report.ReferenceSupply)
+	switch report.Difference.Sign() {
+	case 1:
+		fmt.Printf("surplus: %s\n", report.Difference)
+	case -1:
+		fmt.Printf("deficit: %s\n", new(big.Int).Neg(report.Difference))
+	default:
+		fmt.Println("supplies match")
+	}
+	fmt.Printf("%d addresses differ:\n", len(report.Diffs))
+	for _, diff := range report.Diffs {
+		fmt.Printf("%s %s: %d (reference %d, delta %s)\n", diff.Address, diff.State, diff.Balance, diff.ReferenceBalance, diff.Delta)
+	}
 
-	fmt.Println("reading in spent addresses...")
-	spentAddrs := make([][]byte, 0)
-	saIt := db.NewIteratorCF(ro, cfs[1])
-	saIt.SeekToFirst()
-	for saIt = saIt; saIt.Valid(); saIt.Next() {
-		keyCopy := make([]byte, len(saIt.Key().Data()))
-		copy(keyCopy, saIt.Key().Data())
-		spentAddrs = append(spentAddrs, keyCopy)
-		saIt.Key().Free()
-		saIt.Value().Free()
+	if *auditReportFile != "" {
+		reportJSON, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*auditReportFile, append(reportJSON, '\n'), 0660); err != nil {
+			return err
+		}
+		fmt.Printf("wrote audit report to %s\n", *auditReportFile)
 	}
-	fmt.Printf("read %d spent addresses\n", len(spentAddrs))
+	return nil
+}
 
-	fmt.Println("writing spent addresses...")
-	exportFile, err := os.OpenFile(*addrExpFileName, os.O_WRONLY|os.O_CREATE, 0660)
-	must(err)
+func reportFundedSpentAddresses() error {
+	source := *fundedSpentAddrsSource
+	spentSource := *fundedSpentAddrsSpentSource
+	stream := func(fn func(addr []byte) error) error {
+		return readSpentAddressesSource(spentSource, fn)
+	}
+	if spentSource == "" {
+		if path.Ext(source) == ".state" || strings.Contains(source, ",") {
+			return errors.Wrap(errInvalidUsage, "local snapshot files contain no spent addresses, use -funded-spent-addresses-spent-source to define them")
+		}
+		spentSource = source
+		stream = func(fn func(addr []byte) error) error {
+			return streamLocalSnapshotSpentAddresses(source, fn)
+		}
+	}
 
-	must(binary.Write(exportFile, binary.LittleEndian, int32(len(spentAddrs))))
-	for _, v := range spentAddrs {
-		_, err := exportFile.Write(v)
-		must(err)
+	fmt.Printf("reading ledger of %s...\n", source)
+	ledger, err := readLedger(source)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("checking spent addresses of %s...\n", spentSource)
+	report, err := snapshot.FindFundedSpentAddresses(ledger, stream)
+	if err != nil {
+		return err
 	}
+	report.Source = source
+	report.SpentAddressesSource = spentSource
 
-	must(exportFile.Close())
+	for _, addr := range report.Addresses {
+		fmt.Printf("%s: %d\n", addr.Address, addr.Balance)
+	}
+	fmt.Printf("%d of %d ledger entries are funded spent addresses (checked %d spent addresses), total value: %s\n",
+		len(report.Addresses), len(ledger), report.SpentAddresses, report.Total)
 
-	fmt.Printf("finished, took %v\n", time.Now().Sub(s))
+	if *fundedSpentAddrsReportFile != "" {
+		reportJSON, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*fundedSpentAddrsReportFile, append(reportJSON, '\n'), 0660); err != nil {
+			return err
+		}
+		fmt.Printf("wrote funded spent addresses report to %s\n", *fundedSpentAddrsReportFile)
+	}
+	return nil
+}
+
+// streamLocalSnapshotSpentAddresses passes the spent addresses of the given localsnapshots-db folder or export file to the given function.
+func streamLocalSnapshotSpentAddresses(source string, fn func(addr []byte) error) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		_, err := streamExportFile(source, snapshot.ExportConsumer{
+			Header: func(header *snapshot.ExportHeader) error {
+				if header.HasCuckooFilter() {
+					return errors.Wrapf(errInvalidUsage, "file version %d contains the spent addresses as cuckoo filter, "+
+						"use -funded-spent-addresses-spent-source to define the spent addresses", header.Version)
+				}
+				return nil
+			},
+			SpentAddress: fn,
+		})
+		return err
+	}
+
+	store, err := openStoreReadOnly(source, storage.LocalSnapshotsDBColumnFamilies)
+	if err != nil {
+		return errors.Wrapf(err, "could not open database %s", source)
+	}
+	defer store.Close()
+	return forEachKey(store, storage.ColumnFamilySpentAddresses, fn)
+}
+
+func diffLocalSnapshots() error {
+	if *diffFrom == "" || *diffTo == "" {
+		return errors.Wrap(errInvalidUsage, "you must define the sources to diff from and to")
+	}
+	if *diffFormat != "text" && *diffFormat != "json" {
+		return errors.Wrapf(errInvalidUsage, "unknown diff format %s, expected text or json", *diffFormat)
+	}
+
+	fmt.Printf("reading local snapshot of %s...\n", *diffFrom)
+	from, _, err := readLocalSnapshotWithCounts(*diffFrom)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("reading local snapshot of %s...\n", *diffTo)
+	to, _, err := readLocalSnapshotWithCounts(*diffTo)
+	if err != nil {
+		return err
+	}
+
+	diff := snapshot.Diff(from, to)
+	diff.From = *diffFrom
+	diff.To = *diffTo
+
+	var out io.Writer = os.Stdout
+	if *diffOutputFile != "" {
+		file, err := os.OpenFile(*diffOutputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	bufOut := bufio.NewWriter(out)
+
+	if *diffFormat == "json" {
+		diffJSON, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		if _, err := bufOut.Write(append(diffJSON, '\n')); err != nil {
+			return err
+		}
+	} else if err := diff.WriteText(bufOut); err != nil {
+		return err
+	}
+	if err := bufOut.Flush(); err != nil {
+		return err
+	}
+
+	if *diffOutputFile != "" {
+		fmt.Printf("wrote diff to %s\n", *diffOutputFile)
+	}
+	if diff.Empty() {
+		fmt.Println("the local snapshots are identical")
+	}
+	return nil
+}
+
+// readLedger reads the ledger state of the given localsnapshots-db folder, export file, comma separated meta and state files
+// or state file (needs to end in .state).
+func readLedger(source string) (map[trinary.Hash]uint64, error) {
+	if path.Ext(source) != ".state" || strings.Contains(source, ",") {
+		ls, _, err := readLocalSnapshotWithCounts(source)
+		if err != nil {
+			return nil, err
+		}
+		return ls.LedgerState, nil
+	}
+
+	file, err := os.OpenFile(source, os.O_RDONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	ledger, err := snapshot.ReadState(file, snapshot.TextOptions{Source: source, OnInvalidTrytes: onInvalidTrytes})
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read state file %s", source)
+	}
+	return ledger, nil
+}
+
+// readLocalSnapshotWithCounts reads the local snapshot of the given localsnapshots-db folder, export file
+// or comma separated meta and state files and the counts declared by it.
+func readLocalSnapshotWithCounts(source string) (*snapshot.Snapshot, *snapshot.Counts, error) {
+	if files := strings.Split(source, ","); len(files) == 2 {
+		ls, counts, err := snapshot.ReadFromFilesWithCounts(snapshot.FilesOptions{MetaFile: files[0], StateFile: files[1], OnInvalidTrytes: onInvalidTrytes})
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "could not read local snapshot files")
+		}
+		return ls, counts, nil
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !info.IsDir() {
+		file, err := os.OpenFile(source, os.O_RDONLY, 0666)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer file.Close()
+		exp, err := snapshot.ReadExport(bufio.NewReader(file), snapshot.ReadExportOptions{SkipSpentAddresses: true})
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "could not read export file %s", source)
+		}
+		if err := useExportNetwork(exp.Header); err != nil {
+			return nil, nil, err
+		}
+		return exp.Snapshot, &snapshot.Counts{
+			SolidEntryPoints: exp.Header.SolidEntryPointsCount,
+			SeenMilestones:   exp.Header.SeenMilestonesCount,
+			LedgerEntries:    exp.Header.LedgerEntriesCount,
+		}, nil
+	}
+
+	store, err := openStoreReadOnly(source, storage.LocalSnapshotsDBColumnFamilies)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "could not open database %s", source)
+	}
+	defer store.Close()
+
+	value, err := store.Get(storage.ColumnFamilyLocalSnapshots, localSnapshotDBKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(value) == 0 {
+		return nil, nil, errors.Errorf("no local snapshot in %s persisted", source)
+	}
+
+	ls, err := snapshot.FromBytes(value)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not parse persisted local snapshot")
+	}
+	counts, err := snapshot.ReadCounts(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ls, counts, nil
+}
+
+// exportFileSpentAddressesSorted checks whether the spent addresses of the given export file are in strictly ascending order.
+func exportFileSpentAddressesSorted(fileName string) (bool, error) {
+	sorted := true
+	var prev []byte
+	_, err := streamExportFile(fileName, snapshot.ExportConsumer{SpentAddress: func(addr []byte) error {
+		if prev != nil && bytes.Compare(prev, addr) >= 0 {
+			sorted = false
+		}
+		prev = append(prev[:0], addr...)
+		return nil
+	}})
+	return sorted, err
+}
+
+// spentAddressesFileSorted checks whether the spent addresses of the given spent addresses file are in strictly ascending order,
+// which is only guaranteed for files written by this program.
+func spentAddressesFileSorted(fileName string) (bool, error) {
+	sorted := true
+	var prev []byte
+	err := readSpentAddressesSource(fileName, func(addr []byte) error {
+		if prev != nil && bytes.Compare(prev, addr) >= 0 {
+			sorted = false
+		}
+		prev = addr
+		return nil
+	})
+	return sorted, err
 }
 
-func generateExportFile() {
+// streamExportFile streams the export file with the given name to the given consumer.
+func streamExportFile(fileName string, consumer snapshot.ExportConsumer) ([sha256.Size]byte, error) {
+	file, err := os.OpenFile(fileName, os.O_RDONLY, 0666)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	defer file.Close()
+
+	hash, err := snapshot.StreamExport(bufio.NewReader(file), consumer)
+	if err != nil {
+		return hash, errors.Wrapf(err, "could not read export file %s", fileName)
+	}
+	return hash, nil
+}
+
+func generateSpentAddressesExportFile() error {
 	s := time.Now()
 
-	cfOpt := gorocksdb.NewDefaultOptions()
-	cfOpts := []*gorocksdb.Options{cfOpt, cfOpt, cfOpt}
+	store, err := openStoreReadOnly(*localSnapshotsDBTarget, storage.LocalSnapshotsDBColumnFamilies)
+	if err != nil {
+		return errors.Wrapf(err, "could not open database %s", *localSnapshotsDBTarget)
+	}
+	defer store.Close()
 
-	db, cfs, err := gorocksdb.OpenDbColumnFamilies(defaultOpts(), *localSnapshotsDBTarget, []string{"default", "spent-addresses", "localsnapshots"}, cfOpts)
-	must(err)
-	defer db.Close()
+	fmt.Println("counting spent addresses...")
+	spentAddrsCount, err := countKeys(store, storage.ColumnFamilySpentAddresses)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("counted %d spent addresses\n", spentAddrsCount)
 
-	// read persisted local snapshot
-	ro := gorocksdb.NewDefaultReadOptions()
-	lsIt := db.NewIteratorCF(ro, cfs[2])
-	lsIt.SeekToFirst()
+	fmt.Printf("writing version %d spent addresses file %s\n", snapshot.SpentAddressesFileVersion, *addrExpFileName)
+	exportFile, err := os.OpenFile(*addrExpFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+	defer exportFile.Close()
+	w := bufio.NewWriterSize(exportFile, exportFileBufferSize)
 
-	if !lsIt.Valid() {
-		fmt.Printf("no local snapshot in %s persisted\n", *localSnapshotsDBTarget)
-		return
+	sha256Hash, err := snapshot.WriteSpentAddressesFile(w, spentAddrsCount, func(fn func(addr []byte) error) error {
+		return forEachKey(store, storage.ColumnFamilySpentAddresses, fn)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "could not write spent addresses file %s", *addrExpFileName)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := exportFile.Close(); err != nil {
+		return err
 	}
 
-	fmt.Printf("persisted local snapshot is %d KBs in size\n", len(lsIt.Value().Data())/1024)
-	ls := readLocalSnapshotFromBytes(lsIt.Value().Data())
-	defer lsIt.Key().Free()
-	defer lsIt.Value().Free()
+	fmt.Printf("sha256: %x\n", sha256Hash)
+	fmt.Printf("finished, took %v\n", time.Now().Sub(s))
+	return nil
+}
+
+func printSpentAddressesFileInfo() error {
+	file, err := os.OpenFile(*addrExpFileName, os.O_RDONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	sorted := true
+	var prev []byte
+	header, hash, err := snapshot.StreamSpentAddressesFile(file, func(addr []byte) error {
+		if prev != nil && bytes.Compare(prev, addr) >= 0 {
+			sorted = false
+		}
+		prev = append(prev[:0], addr...)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "could not read spent addresses file %s", *addrExpFileName)
+	}
+
+	fmt.Println("file version:", header.Version)
+	fmt.Printf("contains %d spent addresses\n", header.Count)
+	fmt.Printf("sorted: %v\n", sorted)
+	fmt.Printf("read a total of %d KBs\n", int(header.Count)*snapshot.HashBytesSize/1024)
+	if !header.HasChecksum() {
+		if header.TrailingBytes > 0 {
+			fmt.Printf("file contains %d stale bytes after the declared spent addresses\n", header.TrailingBytes)
+		}
+		fmt.Printf("file version %d contains no checksum, data integrity can not be checked\n", header.Version)
+		return nil
+	}
+	fmt.Printf("data integrity check successful (sha256): %x\n", hash)
+	return nil
+}
+
+func generateExportFile() error {
+	s := time.Now()
+
+	expOpts, err := exportOptions()
+	if err != nil {
+		return err
+	}
+
+	store, err := openStoreReadOnly(*localSnapshotsDBTarget, storage.LocalSnapshotsDBColumnFamilies)
+	if err != nil {
+		return errors.Wrapf(err, "could not open database %s", *localSnapshotsDBTarget)
+	}
+	defer store.Close()
+
+	ls, err := readLocalSnapshotFromDB(store)
+	if err != nil {
+		return err
+	}
+	if ls == nil {
+		fmt.Printf("no local snapshot in %s persisted\n", *localSnapshotsDBTarget)
+		return nil
+	}
 
 	fmt.Println("read following local snapshot from the database:")
 	printLocalSnapshotFilesInfo(ls)
 
-	spentAddrs := make([][]byte, 0)
 	if *expOmitSpentAddrs {
 		fmt.Println("omitting spent addresses in export file")
 	} else {
-		fmt.Println("reading in spent addresses...")
-		saIt := db.NewIteratorCF(ro, cfs[1])
-		saIt.SeekToFirst()
-		for saIt = saIt; saIt.Valid(); saIt.Next() {
-			keyCopy := make([]byte, len(saIt.Key().Data()))
-			copy(keyCopy, saIt.Key().Data())
-			spentAddrs = append(spentAddrs, keyCopy)
-			saIt.Key().Free()
-			saIt.Value().Free()
-		}
-		fmt.Printf("read %d spent addresses\n", len(spentAddrs))
-	}
-
-	fmt.Println("writing in-memory binary buffer")
-	var buf bytes.Buffer
-	msHashBytes, err := trinary.TrytesToBytes(ls.msHash)
-	must(err)
-	must(binary.Write(&buf, binary.LittleEndian, expFileVersion))
-	must(binary.Write(&buf, binary.LittleEndian, msHashBytes))
-	must(binary.Write(&buf, binary.LittleEndian, ls.msIndex))
-	must(binary.Write(&buf, binary.LittleEndian, ls.msTimestamp))
-	must(binary.Write(&buf, binary.LittleEndian, int32(len(ls.solidEntryPoints))))
-	must(binary.Write(&buf, binary.LittleEndian, int32(len(ls.seenMilestones))))
-	must(binary.Write(&buf, binary.LittleEndian, int32(len(ls.ledgerState))))
-	must(binary.Write(&buf, binary.LittleEndian, int32(len(spentAddrs))))
-
-	for k, v := range ls.solidEntryPoints {
-		raw, err := trinary.TrytesToBytes(k)
-		must(err)
-		must(binary.Write(&buf, binary.LittleEndian, raw))
-		must(binary.Write(&buf, binary.LittleEndian, v))
-	}
-	for k, v := range ls.seenMilestones {
-		raw, err := trinary.TrytesToBytes(k)
-		must(err)
-		must(binary.Write(&buf, binary.LittleEndian, raw))
-		must(binary.Write(&buf, binary.LittleEndian, v))
-	}
-	for k, v := range ls.ledgerState {
-		raw, err := trinary.TrytesToBytes(k)
-		must(err)
-		must(binary.Write(&buf, binary.LittleEndian, raw))
-		must(binary.Write(&buf, binary.LittleEndian, v))
-	}
-	for _, v := range spentAddrs {
-		must(binary.Write(&buf, binary.LittleEndian, v))
-	}
-
-	fmt.Printf("wrote in-memory binary buffer (%d KBs)\n", buf.Len()/1024)
-	fmt.Printf("writing binary stream to file %s\n", *expFileName)
+		fmt.Println("counting spent addresses...")
+		expOpts.SpentAddressesCount, err = countKeys(store, storage.ColumnFamilySpentAddresses)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("counted %d spent addresses\n", expOpts.SpentAddressesCount)
+		expOpts.SpentAddresses = func(fn func(addr []byte) error) error {
+			return forEachKey(store, storage.ColumnFamilySpentAddresses, fn)
+		}
+	}
+
+	fmt.Printf("writing version %d binary stream to file %s\n", expOpts.Version, *expFileName)
 
 	os.Remove(*expFileName)
 	exportFile, err := os.OpenFile(*expFileName, os.O_WRONLY|os.O_CREATE, 0660)
-	must(err)
-
-	sha256Hash := sha256.Sum256(buf.Bytes())
-	must(binary.Write(&buf, binary.LittleEndian, sha256Hash))
+	if err != nil {
+		return err
+	}
+	defer exportFile.Close()
+	w := bufio.NewWriterSize(exportFile, exportFileBufferSize)
 
-	_, err = io.Copy(exportFile, bytes.NewBuffer(buf.Bytes()))
-	must(err)
+	sha256Hash, err := snapshot.WriteExport(w, ls, expOpts)
+	if err != nil {
+		return errors.Wrapf(err, "could not write export file %s", *expFileName)
+	}
 
 	// clean up
-	must(exportFile.Close())
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := exportFile.Close(); err != nil {
+		return err
+	}
 
 	fmt.Printf("sha256: %x\n", sha256Hash)
 	fmt.Printf("finished, took %v\n", time.Now().Sub(s))
+	return nil
 }
 
-func defaultOpts() *gorocksdb.Options {
-	// db opts
-	opts := gorocksdb.NewDefaultOptions()
-	opts.SetCreateIfMissing(true)
-	opts.SetCreateIfMissingColumnFamilies(true)
-	opts.SetMaxOpenFiles(10000)
-	opts.SetMaxBackgroundCompactions(1)
-	opts.SetMaxLogFileSize(1024 * 1024)
-	opts.SetMaxManifestFileSize(1024 * 1024)
+// putLocalSnapshot persists the given local snapshot in the localsnapshots column family of the given store
+// and reads it back to make sure it was written correctly.
+func putLocalSnapshot(store storage.Store, ls *snapshot.Snapshot) error {
+	lsBytes, err := ls.Bytes()
+	if err != nil {
+		return err
+	}
 
-	// block based table opts
-	bbto := gorocksdb.NewDefaultBlockBasedTableOptions()
-	bloomFilter := gorocksdb.NewBloomFilter(bloomFilterBitsPerKey)
-	bbto.SetFilterPolicy(bloomFilter)
-	bbto.SetBlockSizeDeviation(10)
-	bbto.SetBlockRestartInterval(blockRestartInterval)
-	bbto.SetBlockSizeDeviation(blockSizeDeviation)
-	bbto.SetBlockCache(gorocksdb.NewLRUCache(blockCacheSize))
-	opts.SetBlockBasedTableFactory(bbto)
+	if err := store.Put(storage.ColumnFamilyLocalSnapshots, localSnapshotDBKey, lsBytes); err != nil {
+		return err
+	}
 
-	opts.SetTableCacheNumshardbits(cacheNumShardBits)
-	return opts
-}
+	value, err := store.Get(storage.ColumnFamilyLocalSnapshots, localSnapshotDBKey)
+	if err != nil {
+		return err
+	}
 
-type localsnapshot struct {
-	msHash           string
-	msIndex          int32
-	msTimestamp      int64
-	solidEntryPoints map[string]int32
-	seenMilestones   map[string]int32
-	ledgerState      map[string]uint64
+	if !bytes.Equal(value, lsBytes) {
+		return errors.Wrapf(snapshot.ErrCorrupted, "persisted local snapshot differs from the written one (%d vs. %d bytes)", len(value), len(lsBytes))
+	}
+	if _, err := snapshot.FromBytes(value); err != nil {
+		return errors.Wrap(err, "could not parse persisted local snapshot")
+	}
+	return nil
 }
 
-func (ls *localsnapshot) SizeInBytes() int {
-	return 49 + 20 + (len(ls.solidEntryPoints) * (49 + 4)) + (len(ls.seenMilestones) * (49 + 4)) + (len(ls.ledgerState) * (49 + 8))
+// readLocalSnapshotFromDB reads the local snapshot persisted in the localsnapshots column family of the given store.
+// It returns nil if no local snapshot is persisted.
+func readLocalSnapshotFromDB(store storage.Store) (*snapshot.Snapshot, error) {
+	value, err := store.Get(storage.ColumnFamilyLocalSnapshots, localSnapshotDBKey)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	fmt.Printf("persisted local snapshot is %d KBs in size\n", len(value)/1024)
+	ls, err := snapshot.FromBytes(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse persisted local snapshot")
+	}
+	return ls, nil
 }
 
-func (ls *localsnapshot) Bytes() []byte {
-	buf := bytes.NewBuffer(make([]byte, 0, ls.SizeInBytes()))
-	msHashBytes, err := trinary.TrytesToBytes(ls.msHash)
-	must(err)
-	must(binary.Write(buf, binary.BigEndian, msHashBytes))
-	must(binary.Write(buf, binary.BigEndian, ls.msIndex))
-	must(binary.Write(buf, binary.BigEndian, ls.msTimestamp))
-	must(binary.Write(buf, binary.BigEndian, int32(len(ls.solidEntryPoints))))
-	must(binary.Write(buf, binary.BigEndian, int32(len(ls.seenMilestones))))
+// forEachKey passes every key of the given column family in order to the given function.
+// The passed key is only valid for the duration of the call.
+func forEachKey(store storage.Store, cf string, fn func(key []byte) error) error {
+	return store.ForEach(cf, func(key []byte, _ []byte) error {
+		return fn(key)
+	})
+}
 
-	for hash, val := range ls.solidEntryPoints {
-		addrBytes, err := trinary.TrytesToBytes(hash)
-		must(err)
-		must(binary.Write(buf, binary.BigEndian, addrBytes))
-		must(binary.Write(buf, binary.BigEndian, val))
-	}
+// countKeys returns the amount of keys within the given column family.
+func countKeys(store storage.Store, cf string) (int32, error) {
+	var count int32
+	err := forEachKey(store, cf, func(_ []byte) error {
+		count++
+		return nil
+	})
+	return count, err
+}
 
-	for hash, val := range ls.seenMilestones {
-		addrBytes, err := trinary.TrytesToBytes(hash)
-		must(err)
-		must(binary.Write(buf, binary.BigEndian, addrBytes))
-		must(binary.Write(buf, binary.BigEndian, val))
-	}
+func printLocalSnapshotFilesInfo(ls *snapshot.Snapshot) {
+	fmt.Printf("ms index/hash/timestamp: %d/%s/%d\nsolid entry points: %d\nseen milestones: %d\nledger entries: %d\n",
+		ls.MilestoneIndex, ls.MilestoneHash, ls.MilestoneTimestamp, len(ls.SolidEntryPoints), len(ls.SeenMilestones), len(ls.LedgerState))
+	total, overflow := ls.TotalSupply()
+	printSupplyCheck(total, overflow)
+	fmt.Printf("size: %d KBs\n", ls.SizeInBytes()/1024)
+}
 
-	for hash, val := range ls.ledgerState {
-		addrBytes, err := trinary.TrytesToBytes(hash)
-		must(err)
-		must(binary.Write(buf, binary.BigEndian, addrBytes))
-		must(binary.Write(buf, binary.BigEndian, val))
+// printSupplyCheck prints whether the given total supply matches the supply of the selected network.
+func printSupplyCheck(total uint64, overflow bool) {
+	if !network.CheckSupply {
+		fmt.Printf("supply: %d (not checked for network %s)\n", total, network.Name)
+		return
 	}
-	return buf.Bytes()
+	fmt.Printf("max supply correct: %v\n", !overflow && total == network.Supply)
 }
 
-func printLocalSnapshotFilesInfo(ls *localsnapshot) {
-	fmt.Printf("ms index/hash/timestamp: %d/%s/%d\nsolid entry points: %d\nseen milestones: %d\nledger entries: %d\n",
-		ls.msIndex, ls.msHash, ls.msTimestamp, len(ls.solidEntryPoints), len(ls.seenMilestones), len(ls.ledgerState))
-	var total int64
-	for _, val := range ls.ledgerState {
-		total += int64(val)
+func readLocalSnapshotFromFiles() (*snapshot.Snapshot, error) {
+	ls, err := snapshot.ReadFromFiles(snapshot.FilesOptions{MetaFile: *lsMetaFileName, StateFile: *lsStateFileName, OnInvalidTrytes: onInvalidTrytes})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read local snapshot files")
 	}
-	fmt.Printf("max supply correct: %v\n", total == 2779530283277761)
-	fmt.Printf("size: %d KBs\n", ls.SizeInBytes()/1024)
+	return ls, nil
 }
 
-func readLocalSnapshotFromBytes(rawLS []byte) *localsnapshot {
-	ls := &localsnapshot{
-		solidEntryPoints: make(map[string]int32),
-		seenMilestones:   make(map[string]int32),
-		ledgerState:      make(map[string]uint64),
-	}
-
-	hashBuf := make([]byte, 49)
-	var solidEntryPointsCount, seenMilestonesCount int32
-	buf := bytes.NewBuffer(rawLS)
-
-	// read milestone hash
-	must(binary.Read(buf, binary.BigEndian, hashBuf))
-	hash, err := trinary.BytesToTrytes(hashBuf)
-	must(err)
-	ls.msHash = hash[:81]
-
-	// nums
-	must(binary.Read(buf, binary.BigEndian, &ls.msIndex))
-	must(binary.Read(buf, binary.BigEndian, &ls.msTimestamp))
-	must(binary.Read(buf, binary.BigEndian, &solidEntryPointsCount))
-	must(binary.Read(buf, binary.BigEndian, &seenMilestonesCount))
-
-	for i := 0; i < int(solidEntryPointsCount); i++ {
-		var val int32
-		must(binary.Read(buf, binary.BigEndian, hashBuf))
-		must(binary.Read(buf, binary.BigEndian, &val))
-		hash, err := trinary.BytesToTrytes(hashBuf)
-		must(err)
-		ls.solidEntryPoints[hash[:81]] = val
-	}
-	for i := 0; i < int(seenMilestonesCount); i++ {
-		var val int32
-		must(binary.Read(buf, binary.BigEndian, hashBuf))
-		must(binary.Read(buf, binary.BigEndian, &val))
-		hash, err := trinary.BytesToTrytes(hashBuf)
-		must(err)
-		ls.seenMilestones[hash[:81]] = val
-	}
-
-	// remaining bytes represent the ledger
-	remaining := buf.Len() / (49 + 8)
-	for i := 0; i < remaining; i++ {
-		var val uint64
-		must(binary.Read(buf, binary.BigEndian, hashBuf))
-		must(binary.Read(buf, binary.BigEndian, &val))
-		hash, err := trinary.BytesToTrytes(hashBuf)
-		must(err)
-		ls.ledgerState[hash[:81]] = val
-	}
-
-	return ls
-}
-
-func readLocalSnapshotFromFiles() *localsnapshot {
-	ls := &localsnapshot{
-		solidEntryPoints: make(map[string]int32),
-		seenMilestones:   make(map[string]int32),
-		ledgerState:      make(map[string]uint64),
-	}
-	metaFile, err := os.Open(*lsMetaFileName)
-	must(err)
-	defer metaFile.Close()
-
-	metaScanner := bufio.NewScanner(metaFile)
-	metaScanner.Scan()
-	ls.msHash = metaScanner.Text()
-	metaScanner.Scan()
-	msIndexStr := metaScanner.Text()
-	metaScanner.Scan()
-	msTimestampStr := metaScanner.Text()
-	metaScanner.Scan()
-	solidEntryPointsCountStr := metaScanner.Text()
-	metaScanner.Scan()
-	// skip seen milestones counter
-
-	msIndex, err := strconv.Atoi(msIndexStr)
-	must(err)
-	ls.msIndex = int32(msIndex)
-
-	ls.msTimestamp, err = strconv.ParseInt(msTimestampStr, 10, 64)
-	must(err)
-
-	solidEntryPointsCount, err := strconv.Atoi(solidEntryPointsCountStr)
-	must(err)
-
-	for metaScanner.Scan() {
-		line := metaScanner.Text()
-		split := strings.Split(line, ";")
-		hash := split[0]
-		valStr := split[1]
-		msIndexInt, err := strconv.Atoi(valStr)
-		must(err)
-		msIndex := int32(msIndexInt)
-		if solidEntryPointsCount != 0 {
-			ls.solidEntryPoints[hash] = msIndex
-			solidEntryPointsCount--
-			continue
-		}
-		ls.seenMilestones[hash] = msIndex
-	}
-
-	stateFile, err := os.Open(*lsStateFileName)
-	must(err)
-	defer stateFile.Close()
-
-	stateScanner := bufio.NewScanner(stateFile)
-	for stateScanner.Scan() {
-		line := stateScanner.Text()
-		split := strings.Split(line, ";")
-		addr := split[0]
-		val, err := strconv.ParseUint(split[1], 10, 64)
-		must(err)
-		ls.ledgerState[addr] = val
-	}
-
-	return ls
-}
-
-func generateLocalSnapshotsDB(in chan []byte) {
+func generateLocalSnapshotsDB() error {
 	s := time.Now()
 
-	// column family options
-	cfOpt := gorocksdb.NewDefaultOptions()
-	cfOpts := []*gorocksdb.Options{cfOpt, cfOpt, cfOpt}
-
-	db, cfs, err := gorocksdb.OpenDbColumnFamilies(defaultOpts(), *localSnapshotsDBTarget, []string{"default", "spent-addresses", "localsnapshots"}, cfOpts)
-	must(err)
-	defer db.Close()
+	// the local snapshot is read first, so invalid files are rejected before anything is written
+	ls, err := readLocalSnapshotFromFiles()
+	if err != nil {
+		return err
+	}
+	if err := verifyCheckpoints(ls); err != nil {
+		return err
+	}
 
-	wo := gorocksdb.NewDefaultWriteOptions()
-	defer wo.Destroy()
+	store, err := openStore(*localSnapshotsDBTarget, storage.LocalSnapshotsDBColumnFamilies)
+	if err != nil {
+		return errors.Wrapf(err, "could not open target database %s", *localSnapshotsDBTarget)
+	}
+	defer store.Close()
 
-	var count int
-	for spentAddrBytes := range in {
-		must(db.PutCF(wo, cfs[1], spentAddrBytes, spentAddrVal))
-		count++
-		fmt.Printf("%d\t\r", count)
+	ws := time.Now()
+	count, err := putSortedSpentAddresses(store, func(fn func(addr []byte) error) error {
+		return readSpentAddressesDB(*spentAddrDbDir, fn)
+	})
+	if err != nil {
+		return err
 	}
-	fmt.Printf("persisted %d spent addresses\n", count)
+	fmt.Printf("persisted %d spent addresses (%.0f addresses/s)\n", count, addressesPerSecond(count, ws))
 	fmt.Println("writing local snapshot data...")
-	ls := readLocalSnapshotFromFiles()
 	printLocalSnapshotFilesInfo(ls)
 
 	// persist local snapshot
-	must(db.PutCF(wo, cfs[2], localSnapshotDBKey, ls.Bytes()))
+	if err := putLocalSnapshot(store, ls); err != nil {
+		return err
+	}
 
 	fmt.Printf("finished, took %v\n", time.Now().Sub(s))
+	return nil
 }
 
-func readSpentAddressesDB(out chan []byte, dbDir string) {
-	// column family options
-	cfOpt := gorocksdb.NewDefaultOptions()
-	cfOpts := []*gorocksdb.Options{cfOpt, cfOpt}
-
-	db, cfs, err := gorocksdb.OpenDbColumnFamilies(defaultOpts(), dbDir, []string{"default", "spent-addresses"}, cfOpts)
-	must(err)
-
-	ro := gorocksdb.NewDefaultReadOptions()
+// readSpentAddressesSource passes the spent addresses of the given spent-addresses-db folder, text file (needs to end in .txt)
+// or spent addresses file (needs to end in .bin) to the given function.
+func readSpentAddressesSource(source string, fn func(spentAddrBytes []byte) error) error {
+	switch path.Ext(source) {
+	case ".txt":
+		f, err := os.OpenFile(source, os.O_RDONLY, 0666)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return snapshot.ReadSpentAddressesText(f, snapshot.TextOptions{Source: source, OnInvalidTrytes: onInvalidTrytes}, fn)
+	case ".bin":
+		f, err := os.OpenFile(source, os.O_RDONLY, 0666)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, _, err := snapshot.StreamSpentAddressesFile(f, func(addr []byte) error {
+			return fn(append([]byte(nil), addr...))
+		}); err != nil {
+			return errors.Wrapf(err, "could not read spent addresses file %s", source)
+		}
+		return nil
+	}
+	return readSpentAddressesDB(source, fn)
+}
 
-	it := db.NewIteratorCF(ro, cfs[1])
-	it.SeekToFirst()
-	for it = it; it.Valid(); it.Next() {
-		keyCopy := make([]byte, len(it.Key().Data()))
-		copy(keyCopy, it.Key().Data())
-		out <- keyCopy
-		it.Key().Free()
-		it.Value().Free()
+// readSpentAddressesDB passes a copy of every spent address within the given spent-addresses-db to the given function.
+// Iteration stops at the first error returned by the function.
+func readSpentAddressesDB(dbDir string, fn func(spentAddrBytes []byte) error) error {
+	store, err := openStoreReadOnly(dbDir, storage.SpentAddressesDBColumnFamilies)
+	if err != nil {
+		return errors.Wrapf(err, "could not open spent-addresses database %s", dbDir)
 	}
+	defer store.Close()
 
-	close(out)
+	return forEachKey(store, storage.ColumnFamilySpentAddresses, func(key []byte) error {
+		keyCopy := make([]byte, len(key))
+		copy(keyCopy, key)
+		return fn(keyCopy)
+	})
 }
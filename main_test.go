@@ -0,0 +1,253 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/iotaledger/iri-ls-sa-merger/snapshot"
+	"github.com/iotaledger/iri-ls-sa-merger/storage"
+	"github.com/iotaledger/iri-ls-sa-merger/storage/memory"
+	"github.com/pkg/errors"
+)
+
+// runWithArgs runs the tool with the given command line arguments against databases held in memory by the given opener.
+// The flags of the tool are reset to their defaults afterwards.
+func runWithArgs(t *testing.T, opener storage.Opener, args ...string) error {
+	t.Helper()
+	defer func(prev storage.Opener, prevReadOnly storage.Opener) {
+		openStore, openStoreReadOnly = prev, prevReadOnly
+		flag.VisitAll(func(f *flag.Flag) {
+			if !strings.HasPrefix(f.Name, "test.") {
+				f.Value.Set(f.DefValue)
+			}
+		})
+	}(openStore, openStoreReadOnly)
+	// the stores held in memory are shared by both openers, as they have no files to protect
+	openStore, openStoreReadOnly = opener, opener
+
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return run()
+}
+
+// testHash returns the hash consisting of the given tryte.
+func testHash(tryte string) trinary.Hash {
+	return strings.Repeat(tryte, snapshot.HashTrytesSize)
+}
+
+// writeTestFile writes the given lines into the file with the given name within dir and returns its path.
+func writeTestFile(t *testing.T, dir string, name string, lines ...string) string {
+	t.Helper()
+	fileName := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(fileName, []byte(strings.Join(lines, "\n")+"\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// putTestSpentAddresses writes the given spent addresses into the spent-addresses-db in the given folder.
+func putTestSpentAddresses(t *testing.T, opener storage.Opener, dir string, addrs ...trinary.Hash) {
+	t.Helper()
+	store, err := opener(dir, storage.SpentAddressesDBColumnFamilies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, addr := range addrs {
+		addrBytes, err := trinary.TrytesToBytes(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Put(storage.ColumnFamilySpentAddresses, addrBytes, spentAddrVal); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// expectTestSpentAddresses fails the test if the spent-addresses column family of the database in the given folder
+// does not consist of the given spent addresses, which must be passed in ascending byte order.
+func expectTestSpentAddresses(t *testing.T, opener storage.Opener, dir string, cfs []string, addrs ...trinary.Hash) {
+	t.Helper()
+	store, err := opener(dir, cfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var persisted []trinary.Hash
+	if err := forEachKey(store, storage.ColumnFamilySpentAddresses, func(key []byte) error {
+		addr, err := trinary.BytesToTrytes(key)
+		if err != nil {
+			return err
+		}
+		persisted = append(persisted, addr[:snapshot.HashTrytesSize])
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(persisted, ",") != strings.Join(addrs, ",") {
+		t.Fatalf("expected the spent addresses %v in %s, got %v", addrs, dir, persisted)
+	}
+}
+
+func TestMergeSpentAddressesSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opener := memory.Opener()
+	db := filepath.Join(dir, "spent-addresses-db")
+	putTestSpentAddresses(t, opener, db, testHash("E"), testHash("A"))
+	txt1 := writeTestFile(t, dir, "a.txt", testHash("C"), testHash("A"), testHash("B"))
+	txt2 := writeTestFile(t, dir, "b.txt", testHash("D"), testHash("C"))
+	target := filepath.Join(dir, "merged-spent-addresses-db")
+
+	if err := runWithArgs(t, opener, "-merge-spent-addresses", "-merge-spent-addresses-sources", strings.Join([]string{db, txt1, txt2}, ","),
+		"-merge-spent-addresses-target", target); err != nil {
+		t.Fatal(err)
+	}
+	expectTestSpentAddresses(t, opener, target, storage.SpentAddressesDBColumnFamilies,
+		testHash("A"), testHash("B"), testHash("C"), testHash("D"), testHash("E"))
+
+	// the runs the text files were sorted in are removed
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected only the text files to remain in %s, got %d entries", dir, len(entries))
+	}
+}
+
+func TestGenerateAndVerifyLocalSnapshotsDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localsnapshots-db-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ls := snapshot.New()
+	ls.MilestoneHash = testHash("M")
+	ls.MilestoneIndex = 10
+	ls.MilestoneTimestamp = 1572000000
+	ls.SolidEntryPoints[testHash("9")] = 0
+	ls.SeenMilestones[testHash("S")] = 11
+	ls.LedgerState[testHash("L")] = snapshot.IOTASupply - 1
+	ls.LedgerState[testHash("K")] = 1
+	metaFile, stateFile := filepath.Join(dir, "mainnet.snapshot.meta"), filepath.Join(dir, "mainnet.snapshot.state")
+	if err := snapshot.WriteToFiles(snapshot.FilesOptions{MetaFile: metaFile, StateFile: stateFile}, ls); err != nil {
+		t.Fatal(err)
+	}
+
+	opener := memory.Opener()
+	spentAddrDB := filepath.Join(dir, "spent-addresses-db")
+	putTestSpentAddresses(t, opener, spentAddrDB, testHash("B"), testHash("A"))
+	lsDB := filepath.Join(dir, "localsnapshots-db")
+
+	if err := runWithArgs(t, opener, "-spent-addresses-db-dir", spentAddrDB, "-ls-db-dir", lsDB,
+		"-ls-meta-file", metaFile, "-ls-state-file", stateFile); err != nil {
+		t.Fatal(err)
+	}
+	expectTestSpentAddresses(t, opener, lsDB, storage.LocalSnapshotsDBColumnFamilies, testHash("A"), testHash("B"))
+
+	// the folder tells verify that the source is a database, the stores held in memory leave it empty
+	if err := os.Mkdir(lsDB, 0770); err != nil {
+		t.Fatal(err)
+	}
+	reportFile := filepath.Join(dir, "report.json")
+	if err := runWithArgs(t, opener, "-verify", "-verify-source", lsDB, "-verify-report", reportFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// a local snapshot with a wrong supply fails the verification
+	ls.LedgerState[testHash("K")] = 2
+	store, err := opener(lsDB, storage.LocalSnapshotsDBColumnFamilies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := putLocalSnapshot(store, ls); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	err = runWithArgs(t, opener, "-verify", "-verify-source", lsDB, "-verify-report", reportFile)
+	if errors.Cause(err) != errVerificationFailed {
+		t.Fatalf("expected %v, got %v", errVerificationFailed, err)
+	}
+}
+
+// benchmarkSpentAddresses is the amount of spent addresses written by every iteration of BenchmarkPutSpentAddressesBatched.
+const benchmarkSpentAddresses = 1000000
+
+// BenchmarkPutSpentAddressesBatched writes spent addresses in random order into a new spent-addresses-db
+// using write batches of different sizes with and without the write-ahead log. The database is opened by openStore,
+// i.e. RocksDB or, built with the purego tag, its pure Go implementation.
+func BenchmarkPutSpentAddressesBatched(b *testing.B) {
+	addrs := make([][]byte, benchmarkSpentAddresses)
+	rnd := rand.New(rand.NewSource(1))
+	for i := range addrs {
+		addrs[i] = make([]byte, snapshot.HashBytesSize)
+		rnd.Read(addrs[i])
+	}
+	stream := func(fn func(addr []byte) error) error {
+		for _, addr := range addrs {
+			if err := fn(addr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	defer func(prevBatchSize int, prevDisableWAL bool) {
+		*batchSize, *disableWAL = prevBatchSize, prevDisableWAL
+	}(*batchSize, *disableWAL)
+	for _, size := range []int{1, 100, defaultBatchSize} {
+		for _, wal := range []bool{true, false} {
+			b.Run(fmt.Sprintf("batch=%d/wal=%v", size, wal), func(b *testing.B) {
+				*batchSize, *disableWAL = size, !wal
+				// the progress printed while writing would garble the results
+				stdout := os.Stdout
+				defer func() {
+					os.Stdout = stdout
+				}()
+				devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer devNull.Close()
+				os.Stdout = devNull
+
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					dir, err := ioutil.TempDir("", "batch-")
+					if err != nil {
+						b.Fatal(err)
+					}
+					store, err := openStore(dir, storage.SpentAddressesDBColumnFamilies)
+					if err != nil {
+						b.Fatal(err)
+					}
+					b.StartTimer()
+					if _, err := putSpentAddressesBatched(store, stream); err != nil {
+						b.Fatal(err)
+					}
+					// like at the end of a mode, the store is closed
+					if err := store.Close(); err != nil {
+						b.Fatal(err)
+					}
+					b.StopTimer()
+					os.RemoveAll(dir)
+					b.StartTimer()
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package snapshot
+
+import (
+	"math/big"
+
+	"github.com/iotaledger/iota.go/trinary"
+)
+
+// the states of a differing balance found by AuditLedger.
+const (
+	// BalanceDiffers means the address exists in both ledgers with different balances.
+	BalanceDiffers = "differs"
+	// BalanceMissing means the address only exists in the reference ledger.
+	BalanceMissing = "missing"
+	// BalanceUnexpected means the address does not exist in the reference ledger.
+	BalanceUnexpected = "unexpected"
+)
+
+// BalanceDiff is a balance of an address which differs from the reference ledger.
+type BalanceDiff struct {
+	Address          trinary.Hash `json:"address"`
+	State            string       `json:"state"`
+	Balance          uint64       `json:"balance"`
+	ReferenceBalance uint64       `json:"referenceBalance"`
+	// Delta is the balance minus the reference balance.
+	Delta *big.Int `json:"delta"`
+}
+
+// AuditReport is the result of comparing a ledger against a trusted reference ledger.
+type AuditReport struct {
+	Source          string   `json:"source"`
+	Reference       string   `json:"reference"`
+	Supply          *big.Int `json:"supply"`
+	ReferenceSupply *big.Int `json:"referenceSupply"`
+	// Difference is the supply minus the reference supply: a surplus if positive, a deficit if negative.
+	Difference *big.Int `json:"difference"`
+	// Diffs are the differing balances in ascending order of their addresses.
+	Diffs []BalanceDiff `json:"diffs"`
+}
+
+// AuditLedger compares the given ledger against the given trusted reference ledger
+// and reports every address whose balance differs or is missing in either of them.
+// Supplies are summed up without overflowing.
+func AuditLedger(ledger map[trinary.Hash]uint64, reference map[trinary.Hash]uint64) *AuditReport {
+	report := &AuditReport{
+		Supply:          sumBalances(ledger),
+		ReferenceSupply: sumBalances(reference),
+		Diffs:           []BalanceDiff{},
+	}
+	report.Difference = new(big.Int).Sub(report.Supply, report.ReferenceSupply)
+
+	compareBalances(ledger, reference, func(addr trinary.Hash, balance uint64, referenceBalance uint64, inLedger bool, inReference bool) {
+		switch {
+		case !inReference:
+			report.Diffs = append(report.Diffs, newBalanceDiff(addr, BalanceUnexpected, balance, 0))
+		case !inLedger:
+			report.Diffs = append(report.Diffs, newBalanceDiff(addr, BalanceMissing, 0, referenceBalance))
+		case balance != referenceBalance:
+			report.Diffs = append(report.Diffs, newBalanceDiff(addr, BalanceDiffers, balance, referenceBalance))
+		}
+	})
+
+	return report
+}
+
+func newBalanceDiff(addr trinary.Hash, state string, balance uint64, referenceBalance uint64) BalanceDiff {
+	delta := new(big.Int).SetUint64(balance)
+	delta.Sub(delta, new(big.Int).SetUint64(referenceBalance))
+	return BalanceDiff{Address: addr, State: state, Balance: balance, ReferenceBalance: referenceBalance, Delta: delta}
+}
+
+// compareBalances passes every address of the given ledgers in ascending order to the given function
+// together with its balances and whether it exists in the respective ledger.
+func compareBalances(ledger map[trinary.Hash]uint64, reference map[trinary.Hash]uint64,
+	fn func(addr trinary.Hash, balance uint64, referenceBalance uint64, inLedger bool, inReference bool)) {
+	addrs := sortedBalanceAddresses(ledger)
+	referenceAddrs := sortedBalanceAddresses(reference)
+	for len(addrs) > 0 || len(referenceAddrs) > 0 {
+		switch {
+		case len(referenceAddrs) == 0 || (len(addrs) > 0 && addrs[0] < referenceAddrs[0]):
+			fn(addrs[0], ledger[addrs[0]], 0, true, false)
+			addrs = addrs[1:]
+		case len(addrs) == 0 || referenceAddrs[0] < addrs[0]:
+			fn(referenceAddrs[0], 0, reference[referenceAddrs[0]], false, true)
+			referenceAddrs = referenceAddrs[1:]
+		default:
+			fn(addrs[0], ledger[addrs[0]], reference[addrs[0]], true, true)
+			addrs, referenceAddrs = addrs[1:], referenceAddrs[1:]
+		}
+	}
+}
+
+func sumBalances(ledger map[trinary.Hash]uint64) *big.Int {
+	total := new(big.Int)
+	balance := new(big.Int)
+	for _, val := range ledger {
+		total.Add(total, balance.SetUint64(val))
+	}
+	return total
+}
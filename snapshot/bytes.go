@@ -0,0 +1,183 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/pkg/errors"
+)
+
+// Bytes returns the binary representation of the snapshot as it is persisted
+// in the localsnapshots column family of a localsnapshots-db.
+func (s *Snapshot) Bytes() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, s.SizeInBytes()))
+	if err := s.Write(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write writes the binary representation of the snapshot to the given writer.
+// Entries are written in ascending order of their hashes, so equal snapshots always yield equal bytes.
+func (s *Snapshot) Write(w io.Writer) error {
+	msHashBytes, err := hashToBytes(s.MilestoneHash)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range []interface{}{msHashBytes, s.MilestoneIndex, s.MilestoneTimestamp,
+		int32(len(s.SolidEntryPoints)), int32(len(s.SeenMilestones))} {
+		if err := binary.Write(w, binary.BigEndian, v); err != nil {
+			return err
+		}
+	}
+
+	if err := writeIndexEntries(w, binary.BigEndian, s.SolidEntryPoints); err != nil {
+		return err
+	}
+	if err := writeIndexEntries(w, binary.BigEndian, s.SeenMilestones); err != nil {
+		return err
+	}
+	return writeBalanceEntries(w, binary.BigEndian, s.LedgerState)
+}
+
+// FromBytes parses a snapshot from its binary representation.
+func FromBytes(raw []byte) (*Snapshot, error) {
+	return Read(bytes.NewReader(raw))
+}
+
+// ReadCounts returns the counts declared by the header of the given binary representation of a snapshot.
+// As the ledger entries are not counted in the header, their count is derived from the size of the data.
+func ReadCounts(raw []byte) (*Counts, error) {
+	const headerSize = HashBytesSize + 4 + 8 + 4 + 4
+	if len(raw) < headerSize {
+		return nil, &ErrTruncated{Section: "header", Offset: int64(len(raw))}
+	}
+	counts := &Counts{
+		SolidEntryPoints: int32(binary.BigEndian.Uint32(raw[headerSize-8:])),
+		SeenMilestones:   int32(binary.BigEndian.Uint32(raw[headerSize-4:])),
+	}
+	ledgerBytes := int64(len(raw)-headerSize) - (int64(counts.SolidEntryPoints)+int64(counts.SeenMilestones))*(HashBytesSize+4)
+	counts.LedgerEntries = int32(ledgerBytes / (HashBytesSize + 8))
+	return counts, nil
+}
+
+// Read reads a snapshot in its binary representation from the given reader.
+// The ledger entries are read until the reader is exhausted. Data ending before the declared solid entry points
+// and seen milestones were read yields an ErrTruncated, data not ending at a ledger entry boundary an ErrCorrupted.
+func Read(r io.Reader) (*Snapshot, error) {
+	s := New()
+	or := &offsetReader{r: r}
+
+	hashBuf := make([]byte, HashBytesSize)
+	var solidEntryPointsCount, seenMilestonesCount int32
+
+	// read milestone hash
+	if _, err := io.ReadFull(or, hashBuf); err != nil {
+		return nil, or.truncated(err, "header")
+	}
+	hash, err := bytesToHash(hashBuf)
+	if err != nil {
+		return nil, err
+	}
+	s.MilestoneHash = hash
+
+	// nums
+	for _, v := range []interface{}{&s.MilestoneIndex, &s.MilestoneTimestamp, &solidEntryPointsCount, &seenMilestonesCount} {
+		if err := binary.Read(or, binary.BigEndian, v); err != nil {
+			return nil, or.truncated(err, "header")
+		}
+	}
+
+	if solidEntryPointsCount < 0 || seenMilestonesCount < 0 {
+		return nil, errors.Wrapf(ErrCorrupted, "negative solid entry points count %d or seen milestones count %d", solidEntryPointsCount, seenMilestonesCount)
+	}
+
+	for i := 0; i < int(solidEntryPointsCount); i++ {
+		var val int32
+		hash, err := readHashEntry(or, binary.BigEndian, hashBuf, &val)
+		if err != nil {
+			return nil, or.truncated(err, "solid entry points")
+		}
+		s.SolidEntryPoints[hash] = val
+	}
+
+	for i := 0; i < int(seenMilestonesCount); i++ {
+		var val int32
+		hash, err := readHashEntry(or, binary.BigEndian, hashBuf, &val)
+		if err != nil {
+			return nil, or.truncated(err, "seen milestones")
+		}
+		s.SeenMilestones[hash] = val
+	}
+
+	// remaining bytes represent the ledger
+	for {
+		entryOffset := or.offset
+		var val uint64
+		addr, err := readHashEntry(or, binary.BigEndian, hashBuf, &val)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			// the data does not end at an entry boundary
+			return nil, errors.Wrapf(ErrCorrupted, "%d trailing bytes at offset %d after %d ledger entries",
+				or.offset-entryOffset, entryOffset, len(s.LedgerState))
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.LedgerState[addr] = val
+	}
+
+	return s, nil
+}
+
+// writeHashEntry writes the given hash in its byte encoding followed by the given value.
+func writeHashEntry(w io.Writer, order binary.ByteOrder, hash string, val interface{}) error {
+	hashBytes, err := hashToBytes(hash)
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, order, hashBytes); err != nil {
+		return err
+	}
+	return binary.Write(w, order, val)
+}
+
+// writeIndexEntries writes the given hash to milestone index entries in ascending order of their hashes.
+func writeIndexEntries(w io.Writer, order binary.ByteOrder, entries map[trinary.Hash]int32) error {
+	for _, hash := range sortedIndexHashes(entries) {
+		if err := writeHashEntry(w, order, hash, entries[hash]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBalanceEntries writes the given address to balance entries in ascending order of their addresses.
+func writeBalanceEntries(w io.Writer, order binary.ByteOrder, entries map[trinary.Hash]uint64) error {
+	for _, addr := range sortedBalanceAddresses(entries) {
+		if err := writeHashEntry(w, order, addr, entries[addr]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readHashEntry reads a hash into hashBuf followed by the value pointed to by val.
+// io.EOF is only returned if no bytes were read at all.
+func readHashEntry(r io.Reader, order binary.ByteOrder, hashBuf []byte, val interface{}) (string, error) {
+	if _, err := io.ReadFull(r, hashBuf); err != nil {
+		return "", err
+	}
+	if err := binary.Read(r, order, val); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return bytesToHash(hashBuf)
+}
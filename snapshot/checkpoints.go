@@ -0,0 +1,96 @@
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/iotaledger/iota.go/trinary"
+)
+
+// Checkpoints are the hashes of known-good milestones of the coordinator by their index.
+type Checkpoints map[int32]trinary.Hash
+
+// ReadCheckpoints reads a checkpoint file containing one index;hash line per known-good milestone.
+// The file is parsed strictly like the local snapshot files, hashes are validated via ParseHash
+// and invalid ones are handled as defined by the given options.
+func ReadCheckpoints(r io.Reader, opts TextOptions) (Checkpoints, error) {
+	checkpoints := make(Checkpoints)
+	scanner := newLineScanner(r, opts.Source)
+	for {
+		line, ok, err := scanner.next()
+		if err != nil || !ok {
+			return checkpoints, err
+		}
+		indexStr, hash, err := scanner.split(line)
+		if err != nil {
+			return nil, err
+		}
+		index, err := strconv.ParseInt(indexStr, 10, 32)
+		if err != nil {
+			return nil, scanner.wrap(err, "invalid checkpoint index")
+		}
+		if index < 0 {
+			return nil, scanner.errorf("negative checkpoint index %d", index)
+		}
+		hash, ok, err = scanner.parseHash(hash, opts.OnInvalidTrytes)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if known, has := checkpoints[int32(index)]; has && known != hash {
+			return nil, scanner.errorf("conflicting checkpoint hashes %s and %s for index %d", known, hash, index)
+		}
+		checkpoints[int32(index)] = hash
+	}
+}
+
+// VerifyCheckpoints checks that the milestone, the seen milestones and the solid entry points of the given snapshot
+// match the given checkpoints where they overlap: a milestone whose index is a checkpoint must have the checkpoint's hash
+// and a milestone or solid entry point whose hash is a checkpoint must have the checkpoint's index.
+// It returns the violations and the amount of entries which matched a checkpoint.
+func VerifyCheckpoints(s *Snapshot, checkpoints Checkpoints) ([]Violation, int) {
+	indexes := make(map[trinary.Hash]int32, len(checkpoints))
+	for index, hash := range checkpoints {
+		indexes[hash] = index
+	}
+
+	violations := []Violation{}
+	var matches int
+	check := func(section string, hash trinary.Hash, index int32, byIndex bool) {
+		matched := false
+		if byIndex {
+			if known, has := checkpoints[index]; has {
+				if known != hash {
+					violations = append(violations, Violation{Check: CheckCheckpoint, Section: section, Hash: hash,
+						Message: fmt.Sprintf("hash does not match the checkpoint %s of index %d", known, index)})
+					return
+				}
+				matched = true
+			}
+		}
+		if known, has := indexes[hash]; has {
+			if known != index {
+				violations = append(violations, Violation{Check: CheckCheckpoint, Section: section, Hash: hash,
+					Message: fmt.Sprintf("index %d does not match the checkpoint index %d", index, known)})
+				return
+			}
+			matched = true
+		}
+		if matched {
+			matches++
+		}
+	}
+
+	check(sectionMilestone, s.MilestoneHash, s.MilestoneIndex, true)
+	for _, hash := range sortedIndexHashes(s.SolidEntryPoints) {
+		// solid entry points are transaction hashes, only milestone transactions are checkpoints
+		check(sectionSolidEntryPoints, hash, s.SolidEntryPoints[hash], false)
+	}
+	for _, hash := range sortedIndexHashes(s.SeenMilestones) {
+		check(sectionSeenMilestones, hash, s.SeenMilestones[hash], true)
+	}
+	return violations, matches
+}
@@ -0,0 +1,193 @@
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/iotaledger/iota.go/trinary"
+)
+
+// IndexEntry is a hash to milestone index entry of the solid entry points or seen milestones.
+type IndexEntry struct {
+	Hash  trinary.Hash `json:"hash"`
+	Index int32        `json:"index"`
+}
+
+// IndexChange is a hash whose milestone index changed between two snapshots.
+type IndexChange struct {
+	Hash      trinary.Hash `json:"hash"`
+	FromIndex int32        `json:"fromIndex"`
+	ToIndex   int32        `json:"toIndex"`
+}
+
+// IndexSectionDiff are the differences of the solid entry points or seen milestones of two snapshots.
+type IndexSectionDiff struct {
+	Added   []IndexEntry  `json:"added"`
+	Removed []IndexEntry  `json:"removed"`
+	Changed []IndexChange `json:"changed"`
+}
+
+// BalanceDelta is the change of the balance of an address between two snapshots.
+// An added address has a from balance of zero, a removed one a to balance of zero.
+type BalanceDelta struct {
+	Address     trinary.Hash `json:"address"`
+	FromBalance uint64       `json:"fromBalance"`
+	ToBalance   uint64       `json:"toBalance"`
+	Delta       *big.Int     `json:"delta"`
+}
+
+// LedgerDiff are the differences of the ledger states of two snapshots.
+type LedgerDiff struct {
+	Added   []BalanceDelta `json:"added"`
+	Removed []BalanceDelta `json:"removed"`
+	Changed []BalanceDelta `json:"changed"`
+	// FromSupply and ToSupply are the total supplies of the snapshots.
+	FromSupply *big.Int `json:"fromSupply"`
+	ToSupply   *big.Int `json:"toSupply"`
+	// TotalDelta is the sum of all balance deltas, which equals the change of the total supply.
+	TotalDelta *big.Int `json:"totalDelta"`
+}
+
+// SnapshotDiff are the differences between two snapshots.
+type SnapshotDiff struct {
+	From                   string           `json:"from"`
+	To                     string           `json:"to"`
+	FromMilestoneIndex     int32            `json:"fromMilestoneIndex"`
+	ToMilestoneIndex       int32            `json:"toMilestoneIndex"`
+	FromMilestoneHash      trinary.Hash     `json:"fromMilestoneHash"`
+	ToMilestoneHash        trinary.Hash     `json:"toMilestoneHash"`
+	FromMilestoneTimestamp int64            `json:"fromMilestoneTimestamp"`
+	ToMilestoneTimestamp   int64            `json:"toMilestoneTimestamp"`
+	SolidEntryPoints       IndexSectionDiff `json:"solidEntryPoints"`
+	SeenMilestones         IndexSectionDiff `json:"seenMilestones"`
+	Ledger                 LedgerDiff       `json:"ledger"`
+}
+
+// Diff computes the differences from the given snapshot to the other given snapshot.
+// All differences are listed in ascending order of their hashes.
+func Diff(from *Snapshot, to *Snapshot) *SnapshotDiff {
+	diff := &SnapshotDiff{
+		FromMilestoneIndex:     from.MilestoneIndex,
+		ToMilestoneIndex:       to.MilestoneIndex,
+		FromMilestoneHash:      from.MilestoneHash,
+		ToMilestoneHash:        to.MilestoneHash,
+		FromMilestoneTimestamp: from.MilestoneTimestamp,
+		ToMilestoneTimestamp:   to.MilestoneTimestamp,
+		SolidEntryPoints:       diffIndexSection(from.SolidEntryPoints, to.SolidEntryPoints),
+		SeenMilestones:         diffIndexSection(from.SeenMilestones, to.SeenMilestones),
+		Ledger: LedgerDiff{
+			Added:      []BalanceDelta{},
+			Removed:    []BalanceDelta{},
+			Changed:    []BalanceDelta{},
+			FromSupply: sumBalances(from.LedgerState),
+			ToSupply:   sumBalances(to.LedgerState),
+			TotalDelta: new(big.Int),
+		},
+	}
+
+	compareBalances(to.LedgerState, from.LedgerState, func(addr trinary.Hash, toBalance uint64, fromBalance uint64, inTo bool, inFrom bool) {
+		if inTo && inFrom && toBalance == fromBalance {
+			return
+		}
+		delta := BalanceDelta{Address: addr, FromBalance: fromBalance, ToBalance: toBalance, Delta: new(big.Int).SetUint64(toBalance)}
+		delta.Delta.Sub(delta.Delta, new(big.Int).SetUint64(fromBalance))
+		diff.Ledger.TotalDelta.Add(diff.Ledger.TotalDelta, delta.Delta)
+		switch {
+		case !inFrom:
+			diff.Ledger.Added = append(diff.Ledger.Added, delta)
+		case !inTo:
+			diff.Ledger.Removed = append(diff.Ledger.Removed, delta)
+		default:
+			diff.Ledger.Changed = append(diff.Ledger.Changed, delta)
+		}
+	})
+
+	return diff
+}
+
+func diffIndexSection(from map[trinary.Hash]int32, to map[trinary.Hash]int32) IndexSectionDiff {
+	diff := IndexSectionDiff{Added: []IndexEntry{}, Removed: []IndexEntry{}, Changed: []IndexChange{}}
+	for _, hash := range sortedIndexHashes(to) {
+		fromIndex, has := from[hash]
+		switch {
+		case !has:
+			diff.Added = append(diff.Added, IndexEntry{Hash: hash, Index: to[hash]})
+		case fromIndex != to[hash]:
+			diff.Changed = append(diff.Changed, IndexChange{Hash: hash, FromIndex: fromIndex, ToIndex: to[hash]})
+		}
+	}
+	for _, hash := range sortedIndexHashes(from) {
+		if _, has := to[hash]; !has {
+			diff.Removed = append(diff.Removed, IndexEntry{Hash: hash, Index: from[hash]})
+		}
+	}
+	return diff
+}
+
+// Empty returns whether the snapshots do not differ at all.
+func (d *SnapshotDiff) Empty() bool {
+	return d.FromMilestoneIndex == d.ToMilestoneIndex && d.FromMilestoneHash == d.ToMilestoneHash &&
+		d.FromMilestoneTimestamp == d.ToMilestoneTimestamp &&
+		d.SolidEntryPoints.empty() && d.SeenMilestones.empty() &&
+		len(d.Ledger.Added)+len(d.Ledger.Removed)+len(d.Ledger.Changed) == 0
+}
+
+func (d *IndexSectionDiff) empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Changed) == 0
+}
+
+// WriteText writes the differences in a human-readable form to the given writer.
+// Added entries are prefixed with '+', removed ones with '-' and changed ones with '~'.
+func (d *SnapshotDiff) WriteText(w io.Writer) error {
+	ew := &errWriter{w: w}
+	ew.printf("diff %s -> %s\n", d.From, d.To)
+	ew.printf("milestone index: %d -> %d\n", d.FromMilestoneIndex, d.ToMilestoneIndex)
+	ew.printf("milestone hash: %s -> %s\n", d.FromMilestoneHash, d.ToMilestoneHash)
+	ew.printf("milestone timestamp: %d -> %d\n", d.FromMilestoneTimestamp, d.ToMilestoneTimestamp)
+
+	for _, section := range []struct {
+		name string
+		diff IndexSectionDiff
+	}{
+		{"solid entry points", d.SolidEntryPoints},
+		{"seen milestones", d.SeenMilestones},
+	} {
+		ew.printf("%s: %d added, %d removed, %d changed\n", section.name, len(section.diff.Added), len(section.diff.Removed), len(section.diff.Changed))
+		for _, entry := range section.diff.Added {
+			ew.printf("+ %s;%d\n", entry.Hash, entry.Index)
+		}
+		for _, entry := range section.diff.Removed {
+			ew.printf("- %s;%d\n", entry.Hash, entry.Index)
+		}
+		for _, change := range section.diff.Changed {
+			ew.printf("~ %s;%d -> %d\n", change.Hash, change.FromIndex, change.ToIndex)
+		}
+	}
+
+	ew.printf("ledger entries: %d added, %d removed, %d changed, total delta %s (supply %s -> %s)\n",
+		len(d.Ledger.Added), len(d.Ledger.Removed), len(d.Ledger.Changed), d.Ledger.TotalDelta, d.Ledger.FromSupply, d.Ledger.ToSupply)
+	for _, delta := range d.Ledger.Added {
+		ew.printf("+ %s;%d\n", delta.Address, delta.ToBalance)
+	}
+	for _, delta := range d.Ledger.Removed {
+		ew.printf("- %s;%d\n", delta.Address, delta.FromBalance)
+	}
+	for _, delta := range d.Ledger.Changed {
+		ew.printf("~ %s;%d -> %d (%+d)\n", delta.Address, delta.FromBalance, delta.ToBalance, delta.Delta)
+	}
+	return ew.err
+}
+
+// errWriter is an io.Writer wrapper which keeps the first error of a sequence of writes.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, args...)
+}
@@ -0,0 +1,84 @@
+package snapshot
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrUnsupportedVersion is returned when the format version of a file can not be handled.
+	ErrUnsupportedVersion = errors.New("unsupported file version")
+	// ErrChecksumMismatch is returned when the checksum contained in a file does not match the checksum of its data.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrCorrupted is returned when data can not be decoded exactly, i.e. because of trailing bytes.
+	ErrCorrupted = errors.New("corrupted data")
+	// ErrSpentAddressesNotSorted is returned when spent addresses are not streamed in strictly ascending order.
+	ErrSpentAddressesNotSorted = errors.New("spent addresses not sorted")
+)
+
+// ErrTruncated is returned when data ends before a section was read completely.
+type ErrTruncated struct {
+	// Section is the name of the section which could not be read completely.
+	Section string
+	// Offset is the byte offset at which the data ended.
+	Offset int64
+}
+
+func (e *ErrTruncated) Error() string {
+	return fmt.Sprintf("data truncated in section '%s' at offset %d", e.Section, e.Offset)
+}
+
+// ErrInvalidTrytes is returned when a line of a text source does not contain valid trytes.
+type ErrInvalidTrytes struct {
+	// Source is the name of the source, i.e. the file name.
+	Source string
+	// Line is the line number (starting at 1) within the source.
+	Line int
+	// Err is the reason why the trytes are invalid.
+	Err error
+}
+
+func (e *ErrInvalidTrytes) Error() string {
+	return fmt.Sprintf("invalid trytes in %s at line %d: %v", e.Source, e.Line, e.Err)
+}
+
+// ErrMalformed is returned when a line of a text file does not match the expected format.
+type ErrMalformed struct {
+	// Source is the name of the file.
+	Source string
+	// Line is the number of the malformed line, starting at 1.
+	Line int
+	Err  error
+}
+
+func (e *ErrMalformed) Error() string {
+	return fmt.Sprintf("malformed %s at line %d: %v", e.Source, e.Line, e.Err)
+}
+
+// offsetReader is an io.Reader which keeps track of the amount of bytes read.
+type offsetReader struct {
+	r      io.Reader
+	offset int64
+}
+
+func (or *offsetReader) Read(p []byte) (int, error) {
+	n, err := or.r.Read(p)
+	or.offset += int64(n)
+	return n, err
+}
+
+// truncated converts an unexpected end of data into an ErrTruncated for the given section
+// at the reader's current offset.
+func (or *offsetReader) truncated(err error, section string) error {
+	return truncated(err, section, or.offset)
+}
+
+// truncated converts an unexpected end of data into an ErrTruncated for the given section and offset.
+func truncated(err error, section string, offset int64) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &ErrTruncated{Section: section, Offset: offset}
+	}
+	return err
+}
@@ -0,0 +1,195 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/pkg/errors"
+)
+
+// ExportFileVersion is the latest version of the export file format and the one written by default.
+// Export files of all versions since v1 can be read via StreamExport and ReadExport.
+const ExportFileVersion byte = 5
+
+// ExportOptions defines the data written into an export file in addition to the snapshot.
+type ExportOptions struct {
+	// Version is the export file version to write, either 4 or 5. Defaults to ExportFileVersion.
+	Version byte
+	// NetworkID identifies the network the snapshot belongs to. It is only written into v5 export files.
+	NetworkID string
+	// SpentAddressesCount is the amount of spent addresses streamed by SpentAddresses.
+	SpentAddressesCount int32
+	// SpentAddresses streams the spent addresses in their byte encoding into the export file
+	// by passing them one by one to the given function. They must be passed in strictly ascending byte order,
+	// as iterating a spent-addresses column family does, see SortSpentAddresses otherwise.
+	// It may be nil if no spent addresses are exported.
+	SpentAddresses func(fn func(addr []byte) error) error
+}
+
+// WriteExport writes the given snapshot and the spent addresses defined in the options as an export file
+// to the given writer. All entries are written in ascending order of their hashes, therefore exporting
+// the same data always yields the same file and sha256 hash. The sha256 hash is computed while the data is written and appended to the file,
+// therefore the data is never held in memory as a whole. As many small writes are issued,
+// w should be buffered. It returns the sha256 hash of the written data.
+func WriteExport(w io.Writer, s *Snapshot, opts ExportOptions) ([sha256.Size]byte, error) {
+	switch opts.Version {
+	case 0, ExportFileVersion:
+		return writeExportV5(w, s, opts)
+	case 4:
+		return writeExportV4(w, s, opts)
+	}
+	return [sha256.Size]byte{}, errors.Wrapf(ErrUnsupportedVersion, "file version %d can not be written, only versions 4 and %d", opts.Version, ExportFileVersion)
+}
+
+// writeExportV4 writes a v4 export file, which consists of a fixed sequence of counts
+// followed by the data and a trailing sha256 hash.
+func writeExportV4(w io.Writer, s *Snapshot, opts ExportOptions) ([sha256.Size]byte, error) {
+	var sha256Hash [sha256.Size]byte
+
+	h := sha256.New()
+	mw := io.MultiWriter(w, h)
+
+	msHashBytes, err := hashToBytes(s.MilestoneHash)
+	if err != nil {
+		return sha256Hash, err
+	}
+
+	for _, v := range []interface{}{byte(4), msHashBytes, s.MilestoneIndex, s.MilestoneTimestamp,
+		int32(len(s.SolidEntryPoints)), int32(len(s.SeenMilestones)), int32(len(s.LedgerState)), opts.SpentAddressesCount} {
+		if err := binary.Write(mw, binary.LittleEndian, v); err != nil {
+			return sha256Hash, err
+		}
+	}
+
+	if err := writeIndexEntries(mw, binary.LittleEndian, s.SolidEntryPoints); err != nil {
+		return sha256Hash, err
+	}
+	if err := writeIndexEntries(mw, binary.LittleEndian, s.SeenMilestones); err != nil {
+		return sha256Hash, err
+	}
+	if err := writeBalanceEntries(mw, binary.LittleEndian, s.LedgerState); err != nil {
+		return sha256Hash, err
+	}
+
+	if err := writeSpentAddresses(mw, opts); err != nil {
+		return sha256Hash, err
+	}
+
+	copy(sha256Hash[:], h.Sum(nil))
+	if _, err := w.Write(sha256Hash[:]); err != nil {
+		return sha256Hash, err
+	}
+	return sha256Hash, nil
+}
+
+// writeSpentAddresses writes the spent addresses streamed by the given options to the given writer
+// and checks that they are sorted and that their amount matches the declared one.
+func writeSpentAddresses(w io.Writer, opts ExportOptions) error {
+	var spentAddrsCount int32
+	prev := make([]byte, 0, HashBytesSize)
+	if opts.SpentAddresses != nil {
+		if err := opts.SpentAddresses(func(addr []byte) error {
+			if len(addr) != HashBytesSize {
+				return errors.Errorf("invalid spent address length %d, expected %d", len(addr), HashBytesSize)
+			}
+			if spentAddrsCount > 0 && bytes.Compare(prev, addr) >= 0 {
+				return errors.Wrapf(ErrSpentAddressesNotSorted, "spent address %d is not greater than its predecessor", spentAddrsCount)
+			}
+			prev = append(prev[:0], addr...)
+			spentAddrsCount++
+			_, err := w.Write(addr)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	if spentAddrsCount != opts.SpentAddressesCount {
+		return errors.Errorf("streamed %d spent addresses but %d were declared", spentAddrsCount, opts.SpentAddressesCount)
+	}
+	return nil
+}
+
+// Export is the content of an export file.
+type Export struct {
+	// Header is the header of the export file.
+	Header *ExportHeader
+	// Snapshot is the local snapshot contained in the export file.
+	Snapshot *Snapshot
+	// SpentAddresses are the spent addresses in their byte encoding,
+	// nil if ReadExportOptions.SkipSpentAddresses was set.
+	SpentAddresses [][]byte
+	// CuckooFilter is the serialized spent addresses cuckoo filter of v2 and v3 export files,
+	// nil if ReadExportOptions.SkipSpentAddresses was set.
+	CuckooFilter []byte
+	// Hash is the verified sha256 hash of the export file, zero for versions without a checksum.
+	Hash [sha256.Size]byte
+}
+
+// ReadExportOptions defines how an export file is read.
+type ReadExportOptions struct {
+	// SkipSpentAddresses defines whether to not keep the spent addresses in memory.
+	SkipSpentAddresses bool
+}
+
+// ReadExport reads an export file from the given reader into memory and verifies its sha256 hash.
+// Use StreamExport to process an export file with bounded memory.
+func ReadExport(r io.Reader, opts ReadExportOptions) (*Export, error) {
+	exp := &Export{Snapshot: New()}
+
+	hash, err := StreamExport(r, ExportConsumer{
+		Header: func(header *ExportHeader) error {
+			exp.Header = header
+			exp.Snapshot.MilestoneHash = header.MilestoneHash
+			exp.Snapshot.MilestoneIndex = header.MilestoneIndex
+			exp.Snapshot.MilestoneTimestamp = header.MilestoneTimestamp
+			// the counters of the header are not trusted before the hash was verified, so nothing is allocated up front
+			if !opts.SkipSpentAddresses && !header.HasCuckooFilter() {
+				exp.SpentAddresses = [][]byte{}
+			}
+			return nil
+		},
+		SolidEntryPoint: func(hash trinary.Hash, index int32) error {
+			exp.Snapshot.SolidEntryPoints[hash] = index
+			return nil
+		},
+		SeenMilestone: func(hash trinary.Hash, index int32) error {
+			exp.Snapshot.SeenMilestones[hash] = index
+			return nil
+		},
+		LedgerEntry: func(addr trinary.Hash, balance uint64) error {
+			exp.Snapshot.LedgerState[addr] = balance
+			return nil
+		},
+		SpentAddress: func(addr []byte) error {
+			if !opts.SkipSpentAddresses {
+				exp.SpentAddresses = append(exp.SpentAddresses, append([]byte(nil), addr...))
+			}
+			return nil
+		},
+		CuckooFilter: func(size int32, r io.Reader) error {
+			if opts.SkipSpentAddresses {
+				return nil
+			}
+			filter, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if len(filter) != int(size) {
+				return io.ErrUnexpectedEOF
+			}
+			exp.CuckooFilter = filter
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	exp.Hash = hash
+
+	return exp, nil
+}
@@ -0,0 +1,320 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/pkg/errors"
+)
+
+// the amount of bytes needed to detect the version of an export file:
+// version byte, milestone hash, milestone index, timestamp and the four counters.
+const exportHeadSize = 1 + HashBytesSize + 4 + 8 + 16
+
+// ExportHeader is the header of an export file.
+type ExportHeader struct {
+	// Version is the version of the export file format.
+	Version byte
+	// Compressed defines whether the export file is gzip compressed.
+	Compressed bool
+	// NetworkID identifies the network of the snapshot, only set for v5 export files.
+	NetworkID string
+	// Sections are the descriptors of the sections of v5 export files.
+	Sections              []ExportSection
+	MilestoneHash         trinary.Hash
+	MilestoneIndex        int32
+	MilestoneTimestamp    int64
+	SolidEntryPointsCount int32
+	SeenMilestonesCount   int32
+	LedgerEntriesCount    int32
+	SpentAddressesCount   int32
+}
+
+// ByteOrder returns the byte order used by the export file version.
+// v1 and v2 use big endianness, while v3 and later use little endianness.
+func (h *ExportHeader) ByteOrder() binary.ByteOrder {
+	if h.Version < 3 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// HasChecksum defines whether the export file version contains a trailing sha256 hash.
+// v5 export files additionally contain a checksum per section.
+func (h *ExportHeader) HasChecksum() bool {
+	return h.Version >= 3
+}
+
+// HasCuckooFilter defines whether the export file version contains the spent addresses
+// in form of a cuckoo filter instead of the addresses themselves.
+func (h *ExportHeader) HasCuckooFilter() bool {
+	return h.Version == 2 || h.Version == 3
+}
+
+// SnapshotSizeInBytes returns the size of the binary representation of the snapshot described by the header.
+func (h *ExportHeader) SnapshotSizeInBytes() int {
+	return HashBytesSize + 20 +
+		(int(h.SolidEntryPointsCount) * (HashBytesSize + 4)) +
+		(int(h.SeenMilestonesCount) * (HashBytesSize + 4)) +
+		(int(h.LedgerEntriesCount) * (HashBytesSize + 8))
+}
+
+// ExportConsumer defines the callbacks which are invoked for the sections of an export file while it is streamed.
+// Callbacks which are nil are skipped, the data of their section is nevertheless read and included in the checksum.
+// Returning an error from a callback aborts the streaming.
+type ExportConsumer struct {
+	// Header is called once with the header of the export file.
+	Header func(header *ExportHeader) error
+	// SolidEntryPoint is called for every solid entry point.
+	SolidEntryPoint func(hash trinary.Hash, index int32) error
+	// SeenMilestone is called for every seen milestone.
+	SeenMilestone func(hash trinary.Hash, index int32) error
+	// LedgerEntry is called for every address and its balance.
+	LedgerEntry func(addr trinary.Hash, balance uint64) error
+	// SpentAddress is called for every spent address in its byte encoding.
+	// The passed slice is only valid for the duration of the call.
+	SpentAddress func(addr []byte) error
+	// CuckooFilter is called with the serialized spent addresses cuckoo filter of v2 and v3 export files.
+	// Data which is not read from the passed reader is skipped.
+	CuckooFilter func(size int32, r io.Reader) error
+}
+
+// StreamExport reads an export file section by section from the given reader and passes its content to the
+// callbacks of the given consumer. The sha256 hash of the file is computed while the data is streamed,
+// so memory usage is bounded regardless of the file's size. Since the hash can only be verified at the end,
+// consumers must discard the data they received if ErrChecksumMismatch (or any other error) is returned.
+//
+// All export file versions ever produced by this tool are supported: gzip compression is detected automatically
+// and the byte order and layout are chosen according to the version. Offsets of ErrTruncated errors refer to
+// the decompressed data. It returns the verified sha256 hash of the export file or a zero hash
+// if the version does not contain a checksum (see ExportHeader.HasChecksum).
+func StreamExport(r io.Reader, consumer ExportConsumer) ([sha256.Size]byte, error) {
+	var fileHash [sha256.Size]byte
+
+	header := &ExportHeader{}
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return fileHash, truncated(err, "header", int64(len(magic)))
+	}
+
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		header.Compressed = true
+		gzipReader, err := gzip.NewReader(br)
+		if err != nil {
+			return fileHash, err
+		}
+		defer gzipReader.Close()
+		br = bufio.NewReader(gzipReader)
+	}
+
+	if magic, _ := br.Peek(len(exportFileMagic)); bytes.Equal(magic, exportFileMagic) {
+		fileHash, err = streamExportV5(br, header, consumer)
+	} else {
+		fileHash, err = streamExportLegacy(br, header, consumer)
+	}
+	if err != nil {
+		return fileHash, err
+	}
+
+	if header.Compressed {
+		// the gzip checksum is only verified once the stream is read until its end
+		if _, err := io.Copy(ioutil.Discard, br); err != nil {
+			return fileHash, errors.Wrap(ErrChecksumMismatch, err.Error())
+		}
+	}
+
+	return fileHash, nil
+}
+
+// streamExportLegacy streams v1 to v4 export files.
+func streamExportLegacy(br *bufio.Reader, header *ExportHeader, consumer ExportConsumer) ([sha256.Size]byte, error) {
+	var fileHash [sha256.Size]byte
+
+	head, err := br.Peek(exportHeadSize)
+	if err != nil && len(head) < exportHeadSize-1 {
+		return fileHash, truncated(err, "header", int64(len(head)))
+	}
+	if header.Version, err = detectExportVersion(head, header.Compressed); err != nil {
+		return fileHash, err
+	}
+	order := header.ByteOrder()
+
+	h := sha256.New()
+	or := &offsetReader{r: br}
+	tr := io.TeeReader(or, h)
+
+	// v1 files start directly with the milestone hash
+	if header.Version >= 2 {
+		if _, err := io.ReadFull(tr, make([]byte, 1)); err != nil {
+			return fileHash, or.truncated(err, "header")
+		}
+	}
+
+	// read in milestone hash
+	hashBuf := make([]byte, HashBytesSize)
+	if _, err := io.ReadFull(tr, hashBuf); err != nil {
+		return fileHash, or.truncated(err, "header")
+	}
+	msHash, err := bytesToHash(hashBuf)
+	if err != nil {
+		return fileHash, err
+	}
+	header.MilestoneHash = msHash
+
+	for _, v := range []interface{}{&header.MilestoneIndex, &header.MilestoneTimestamp,
+		&header.SolidEntryPointsCount, &header.SeenMilestonesCount, &header.LedgerEntriesCount, &header.SpentAddressesCount} {
+		if err := binary.Read(tr, order, v); err != nil {
+			return fileHash, or.truncated(err, "header")
+		}
+	}
+
+	if consumer.Header != nil {
+		if err := consumer.Header(header); err != nil {
+			return fileHash, err
+		}
+	}
+
+	for i := 0; i < int(header.SolidEntryPointsCount); i++ {
+		var val int32
+		hash, err := readHashEntry(tr, order, hashBuf, &val)
+		if err != nil {
+			return fileHash, or.truncated(err, "solid entry points")
+		}
+		if consumer.SolidEntryPoint != nil {
+			if err := consumer.SolidEntryPoint(hash, val); err != nil {
+				return fileHash, err
+			}
+		}
+	}
+
+	for i := 0; i < int(header.SeenMilestonesCount); i++ {
+		var val int32
+		hash, err := readHashEntry(tr, order, hashBuf, &val)
+		if err != nil {
+			return fileHash, or.truncated(err, "seen milestones")
+		}
+		if consumer.SeenMilestone != nil {
+			if err := consumer.SeenMilestone(hash, val); err != nil {
+				return fileHash, err
+			}
+		}
+	}
+
+	for i := 0; i < int(header.LedgerEntriesCount); i++ {
+		var val uint64
+		addr, err := readHashEntry(tr, order, hashBuf, &val)
+		if err != nil {
+			return fileHash, or.truncated(err, "ledger entries")
+		}
+		if consumer.LedgerEntry != nil {
+			if err := consumer.LedgerEntry(addr, val); err != nil {
+				return fileHash, err
+			}
+		}
+	}
+
+	if header.HasCuckooFilter() {
+		if err := streamCuckooFilter(tr, or, order, consumer.CuckooFilter); err != nil {
+			return fileHash, err
+		}
+	} else {
+		for i := 0; i < int(header.SpentAddressesCount); i++ {
+			if _, err := io.ReadFull(tr, hashBuf); err != nil {
+				return fileHash, or.truncated(err, "spent addresses")
+			}
+			if consumer.SpentAddress != nil {
+				if err := consumer.SpentAddress(hashBuf); err != nil {
+					return fileHash, err
+				}
+			}
+		}
+	}
+
+	if header.HasChecksum() {
+		// the trailing hash is not part of the hashed data
+		if _, err := io.ReadFull(or, fileHash[:]); err != nil {
+			return fileHash, or.truncated(err, "checksum")
+		}
+
+		computedHash := h.Sum(nil)
+		if !bytes.Equal(fileHash[:], computedHash) {
+			return fileHash, errors.Wrapf(ErrChecksumMismatch, "computed and sha256 hash do not match: %x (file) vs. %x (computed)", fileHash, computedHash)
+		}
+	}
+
+	return fileHash, nil
+}
+
+// streamCuckooFilter passes the size prefixed cuckoo filter of v2 and v3 export files to the given function
+// and skips whatever the function did not read.
+func streamCuckooFilter(tr io.Reader, or *offsetReader, order binary.ByteOrder, fn func(size int32, r io.Reader) error) error {
+	var size int32
+	if err := binary.Read(tr, order, &size); err != nil {
+		return or.truncated(err, "cuckoo filter")
+	}
+	if size < 0 {
+		return errors.Errorf("invalid cuckoo filter size %d", size)
+	}
+
+	filterReader := &io.LimitedReader{R: tr, N: int64(size)}
+	if fn != nil {
+		if err := fn(size, filterReader); err != nil {
+			return or.truncated(err, "cuckoo filter")
+		}
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, tr, filterReader.N); err != nil {
+		return or.truncated(err, "cuckoo filter")
+	}
+	return nil
+}
+
+// detectExportVersion detects the version of an export file given its (decompressed) first bytes.
+// Since v2, files start with their version byte, while v1 files start directly with the milestone hash.
+// As the first byte of a milestone hash may coincidentally look like a version byte,
+// the header's counters are checked for plausibility under the assumed version.
+func detectExportVersion(head []byte, compressed bool) (byte, error) {
+	version := head[0]
+	switch {
+	case version == 4 || version == 3:
+		// v3 and v4 may be plain or compressed
+		if len(head) == exportHeadSize && plausibleExportHead(head[1:], binary.LittleEndian) {
+			return version, nil
+		}
+	case version == 2 && compressed:
+		if len(head) == exportHeadSize && plausibleExportHead(head[1:], binary.BigEndian) {
+			return version, nil
+		}
+	}
+
+	// v1 files were always gzip compressed
+	if compressed && plausibleExportHead(head, binary.BigEndian) {
+		return 1, nil
+	}
+
+	return 0, errors.Wrapf(ErrUnsupportedVersion, "file version %d is not supported (compressed: %v)", version, compressed)
+}
+
+// plausibleExportHead checks whether the milestone index and the counters following the milestone hash are non-negative.
+func plausibleExportHead(head []byte, order binary.ByteOrder) bool {
+	if len(head) < HashBytesSize+4+8+16 {
+		return false
+	}
+	values := head[HashBytesSize:]
+	if int32(order.Uint32(values)) < 0 {
+		return false
+	}
+	for i := 0; i < 4; i++ {
+		if int32(order.Uint32(values[12+i*4:])) < 0 {
+			return false
+		}
+	}
+	return true
+}
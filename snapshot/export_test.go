@@ -0,0 +1,48 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeTestExportHead builds the head of a legacy export file of the given version without any entries,
+// whose last counter is the given spent addresses count, followed by the given data.
+func writeTestExportHead(t *testing.T, version byte, spentAddrsCount int32, data ...interface{}) []byte {
+	msHashBytes, err := hashToBytes(strings.Repeat("9", 81))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, v := range append([]interface{}{version, msHashBytes, int32(1), int64(2), int32(0), int32(0), int32(0), spentAddrsCount}, data...) {
+		if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestReadExportDoesNotAllocateDeclaredCounts(t *testing.T) {
+	files := map[string][]byte{
+		// a v4 file declaring the maximum amount of spent addresses
+		"spent addresses": writeTestExportHead(t, 4, math.MaxInt32),
+		// a v3 file declaring a cuckoo filter of the maximum size
+		"cuckoo filter": writeTestExportHead(t, 3, 0, int32(math.MaxInt32), []byte("filter")),
+	}
+	for name, file := range files {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := ReadExport(bytes.NewReader(file), ReadExportOptions{})
+		runtime.ReadMemStats(&after)
+
+		if _, ok := err.(*ErrTruncated); !ok {
+			t.Fatalf("%s: expected a truncation error, got %v", name, err)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Fatalf("%s: expected the declared count not to be allocated, got %d allocated bytes", name, allocated)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// exportFileMagic is the prefix of v5 and later export files.
+var exportFileMagic = []byte("ILSX")
+
+// ExportSectionType identifies the content of a section of a v5 export file.
+type ExportSectionType byte
+
+const (
+	// SectionSolidEntryPoints contains the solid entry points with their milestone indices.
+	SectionSolidEntryPoints ExportSectionType = iota + 1
+	// SectionSeenMilestones contains the seen milestones with their indices.
+	SectionSeenMilestones
+	// SectionLedgerEntries contains the addresses and balances of the ledger state.
+	SectionLedgerEntries
+	// SectionSpentAddresses contains the spent addresses.
+	SectionSpentAddresses
+)
+
+// String returns the name of the section type as used in error messages.
+func (t ExportSectionType) String() string {
+	switch t {
+	case SectionSolidEntryPoints:
+		return "solid entry points"
+	case SectionSeenMilestones:
+		return "seen milestones"
+	case SectionLedgerEntries:
+		return "ledger entries"
+	case SectionSpentAddresses:
+		return "spent addresses"
+	}
+	return fmt.Sprintf("unknown section %d", byte(t))
+}
+
+// encoding returns the encoding of the entries of the section type.
+func (t ExportSectionType) encoding() ExportSectionEncoding {
+	switch t {
+	case SectionSolidEntryPoints, SectionSeenMilestones:
+		return EncodingHashInt32
+	case SectionLedgerEntries:
+		return EncodingHashUint64
+	case SectionSpentAddresses:
+		return EncodingHash
+	}
+	return 0
+}
+
+// ExportSectionEncoding defines the layout of the entries of a section of a v5 export file.
+type ExportSectionEncoding byte
+
+const (
+	// EncodingHashInt32 entries consist of a hash in its byte encoding followed by an int32.
+	EncodingHashInt32 ExportSectionEncoding = iota + 1
+	// EncodingHashUint64 entries consist of a hash in its byte encoding followed by an uint64.
+	EncodingHashUint64
+	// EncodingHash entries consist of a hash in its byte encoding.
+	EncodingHash
+)
+
+// EntrySize returns the size of an entry in bytes or 0 if the encoding is unknown.
+func (e ExportSectionEncoding) EntrySize() int64 {
+	switch e {
+	case EncodingHashInt32:
+		return HashBytesSize + 4
+	case EncodingHashUint64:
+		return HashBytesSize + 8
+	case EncodingHash:
+		return HashBytesSize
+	}
+	return 0
+}
+
+// ExportSection describes a section of a v5 export file.
+type ExportSection struct {
+	Type     ExportSectionType
+	Encoding ExportSectionEncoding
+	// Count is the amount of entries within the section.
+	Count int32
+	// ByteLength is the size of the section's data in bytes, excluding its checksum.
+	ByteLength int64
+}
+
+func newExportSection(sectionType ExportSectionType, count int) ExportSection {
+	encoding := sectionType.encoding()
+	return ExportSection{Type: sectionType, Encoding: encoding, Count: int32(count), ByteLength: int64(count) * encoding.EntrySize()}
+}
+
+// writeExportV5 writes a v5 export file:
+//
+//	magic -> 4 bytes ("ILSX")
+//	versionByte -> 1 byte
+//	networkIDLength -> 1 byte
+//	networkID -> networkIDLength bytes
+//	milestoneHash -> 49 bytes
+//	milestoneIndex -> int32
+//	snapshotTimestamp -> int64
+//	sectionsCount -> 1 byte
+//	sectionsCount * sectionType:encoding:count:byteLength -> 1 byte + 1 byte + int32 + int64
+//	sectionsCount * sectionData:sha256 -> byteLength bytes + 32 bytes
+//	sha256 hash of the data above -> 32 bytes
+func writeExportV5(w io.Writer, s *Snapshot, opts ExportOptions) ([sha256.Size]byte, error) {
+	var sha256Hash [sha256.Size]byte
+
+	if len(opts.NetworkID) > math.MaxUint8 {
+		return sha256Hash, errors.Errorf("network identifier '%s' exceeds %d bytes", opts.NetworkID, math.MaxUint8)
+	}
+
+	h := sha256.New()
+	mw := io.MultiWriter(w, h)
+
+	msHashBytes, err := hashToBytes(s.MilestoneHash)
+	if err != nil {
+		return sha256Hash, err
+	}
+
+	sections := []ExportSection{
+		newExportSection(SectionSolidEntryPoints, len(s.SolidEntryPoints)),
+		newExportSection(SectionSeenMilestones, len(s.SeenMilestones)),
+		newExportSection(SectionLedgerEntries, len(s.LedgerState)),
+		newExportSection(SectionSpentAddresses, int(opts.SpentAddressesCount)),
+	}
+
+	values := []interface{}{exportFileMagic, ExportFileVersion, uint8(len(opts.NetworkID)), []byte(opts.NetworkID),
+		msHashBytes, s.MilestoneIndex, s.MilestoneTimestamp, uint8(len(sections))}
+	for _, section := range sections {
+		values = append(values, section.Type, section.Encoding, section.Count, section.ByteLength)
+	}
+	for _, v := range values {
+		if err := binary.Write(mw, binary.LittleEndian, v); err != nil {
+			return sha256Hash, err
+		}
+	}
+
+	for _, section := range sections {
+		sectionHash := sha256.New()
+		sw := io.MultiWriter(mw, sectionHash)
+
+		var err error
+		switch section.Type {
+		case SectionSolidEntryPoints:
+			err = writeIndexEntries(sw, binary.LittleEndian, s.SolidEntryPoints)
+		case SectionSeenMilestones:
+			err = writeIndexEntries(sw, binary.LittleEndian, s.SeenMilestones)
+		case SectionLedgerEntries:
+			err = writeBalanceEntries(sw, binary.LittleEndian, s.LedgerState)
+		case SectionSpentAddresses:
+			err = writeSpentAddresses(sw, opts)
+		}
+		if err != nil {
+			return sha256Hash, err
+		}
+
+		if _, err := mw.Write(sectionHash.Sum(nil)); err != nil {
+			return sha256Hash, err
+		}
+	}
+
+	copy(sha256Hash[:], h.Sum(nil))
+	if _, err := w.Write(sha256Hash[:]); err != nil {
+		return sha256Hash, err
+	}
+	return sha256Hash, nil
+}
+
+// streamExportV5 streams a v5 export file. Sections with a mismatching checksum do not abort the streaming,
+// instead all of them are reported once the file was read completely.
+func streamExportV5(br *bufio.Reader, header *ExportHeader, consumer ExportConsumer) ([sha256.Size]byte, error) {
+	var fileHash [sha256.Size]byte
+
+	h := sha256.New()
+	or := &offsetReader{r: br}
+	tr := io.TeeReader(or, h)
+
+	magic := make([]byte, len(exportFileMagic))
+	if _, err := io.ReadFull(tr, magic); err != nil {
+		return fileHash, or.truncated(err, "header")
+	}
+	if err := binary.Read(tr, binary.LittleEndian, &header.Version); err != nil {
+		return fileHash, or.truncated(err, "header")
+	}
+	if header.Version != ExportFileVersion {
+		return fileHash, errors.Wrapf(ErrUnsupportedVersion, "file version %d is not supported", header.Version)
+	}
+
+	var networkIDLength uint8
+	if err := binary.Read(tr, binary.LittleEndian, &networkIDLength); err != nil {
+		return fileHash, or.truncated(err, "header")
+	}
+	networkID := make([]byte, networkIDLength)
+	if _, err := io.ReadFull(tr, networkID); err != nil {
+		return fileHash, or.truncated(err, "header")
+	}
+	header.NetworkID = string(networkID)
+
+	hashBuf := make([]byte, HashBytesSize)
+	if _, err := io.ReadFull(tr, hashBuf); err != nil {
+		return fileHash, or.truncated(err, "header")
+	}
+	msHash, err := bytesToHash(hashBuf)
+	if err != nil {
+		return fileHash, err
+	}
+	header.MilestoneHash = msHash
+
+	var sectionsCount uint8
+	for _, v := range []interface{}{&header.MilestoneIndex, &header.MilestoneTimestamp, &sectionsCount} {
+		if err := binary.Read(tr, binary.LittleEndian, v); err != nil {
+			return fileHash, or.truncated(err, "header")
+		}
+	}
+
+	seen := make(map[ExportSectionType]struct{})
+	for i := 0; i < int(sectionsCount); i++ {
+		var section ExportSection
+		for _, v := range []interface{}{&section.Type, &section.Encoding, &section.Count, &section.ByteLength} {
+			if err := binary.Read(tr, binary.LittleEndian, v); err != nil {
+				return fileHash, or.truncated(err, "section descriptors")
+			}
+		}
+		if err := applySectionDescriptor(header, section, seen); err != nil {
+			return fileHash, err
+		}
+		header.Sections = append(header.Sections, section)
+	}
+
+	if consumer.Header != nil {
+		if err := consumer.Header(header); err != nil {
+			return fileHash, err
+		}
+	}
+
+	var corrupted []string
+	for _, section := range header.Sections {
+		start := or.offset
+		sectionHash := sha256.New()
+		sr := io.TeeReader(tr, sectionHash)
+
+		if err := streamSection(sr, or, section, hashBuf, consumer); err != nil {
+			return fileHash, err
+		}
+
+		sectionChecksum := make([]byte, sha256.Size)
+		if _, err := io.ReadFull(tr, sectionChecksum); err != nil {
+			return fileHash, or.truncated(err, section.Type.String())
+		}
+		if !bytes.Equal(sectionChecksum, sectionHash.Sum(nil)) {
+			corrupted = append(corrupted, fmt.Sprintf("%s (offset %d-%d)", section.Type, start, or.offset))
+		}
+	}
+
+	// the trailing hash is not part of the hashed data
+	if _, err := io.ReadFull(or, fileHash[:]); err != nil {
+		return fileHash, or.truncated(err, "checksum")
+	}
+
+	if len(corrupted) > 0 {
+		return fileHash, errors.Wrapf(ErrChecksumMismatch, "checksums of the following sections do not match: %s", strings.Join(corrupted, ", "))
+	}
+
+	computedHash := h.Sum(nil)
+	if !bytes.Equal(fileHash[:], computedHash) {
+		// all sections are intact, therefore the header must be corrupted
+		return fileHash, errors.Wrapf(ErrChecksumMismatch, "computed and sha256 hash do not match while all sections are intact, the header is corrupted: %x (file) vs. %x (computed)", fileHash, computedHash)
+	}
+
+	return fileHash, nil
+}
+
+// applySectionDescriptor validates the given section descriptor and sets the corresponding count of the header.
+func applySectionDescriptor(header *ExportHeader, section ExportSection, seen map[ExportSectionType]struct{}) error {
+	if section.Count < 0 || section.ByteLength < 0 {
+		return errors.Errorf("invalid descriptor of %s: count %d, byte length %d", section.Type, section.Count, section.ByteLength)
+	}
+
+	expectedEncoding := section.Type.encoding()
+	if expectedEncoding == 0 {
+		// unknown sections are skipped
+		return nil
+	}
+
+	if _, has := seen[section.Type]; has {
+		return errors.Errorf("duplicate descriptor of %s", section.Type)
+	}
+	seen[section.Type] = struct{}{}
+
+	if section.Encoding != expectedEncoding {
+		return errors.Errorf("unsupported encoding %d of %s", section.Encoding, section.Type)
+	}
+	if section.ByteLength != int64(section.Count)*section.Encoding.EntrySize() {
+		return errors.Errorf("invalid descriptor of %s: byte length %d does not match %d entries", section.Type, section.ByteLength, section.Count)
+	}
+
+	switch section.Type {
+	case SectionSolidEntryPoints:
+		header.SolidEntryPointsCount = section.Count
+	case SectionSeenMilestones:
+		header.SeenMilestonesCount = section.Count
+	case SectionLedgerEntries:
+		header.LedgerEntriesCount = section.Count
+	case SectionSpentAddresses:
+		header.SpentAddressesCount = section.Count
+	}
+	return nil
+}
+
+// streamSection passes the entries of the given section to the consumer. Unknown sections are skipped.
+func streamSection(sr io.Reader, or *offsetReader, section ExportSection, hashBuf []byte, consumer ExportConsumer) error {
+	if section.Type.encoding() == 0 {
+		// the entries of unknown sections can not be counted, their data is skipped as a whole
+		if _, err := io.CopyN(ioutil.Discard, sr, section.ByteLength); err != nil {
+			return or.truncated(err, section.Type.String())
+		}
+		return nil
+	}
+
+	for i := 0; i < int(section.Count); i++ {
+		var err error
+		switch section.Type {
+		case SectionSolidEntryPoints, SectionSeenMilestones:
+			var val int32
+			hash, readErr := readHashEntry(sr, binary.LittleEndian, hashBuf, &val)
+			if readErr != nil {
+				return or.truncated(readErr, section.Type.String())
+			}
+			if section.Type == SectionSolidEntryPoints && consumer.SolidEntryPoint != nil {
+				err = consumer.SolidEntryPoint(hash, val)
+			} else if section.Type == SectionSeenMilestones && consumer.SeenMilestone != nil {
+				err = consumer.SeenMilestone(hash, val)
+			}
+		case SectionLedgerEntries:
+			var val uint64
+			addr, readErr := readHashEntry(sr, binary.LittleEndian, hashBuf, &val)
+			if readErr != nil {
+				return or.truncated(readErr, section.Type.String())
+			}
+			if consumer.LedgerEntry != nil {
+				err = consumer.LedgerEntry(addr, val)
+			}
+		case SectionSpentAddresses:
+			if _, readErr := io.ReadFull(sr, hashBuf); readErr != nil {
+				return or.truncated(readErr, section.Type.String())
+			}
+			if consumer.SpentAddress != nil {
+				err = consumer.SpentAddress(hashBuf)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
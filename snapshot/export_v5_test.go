@@ -0,0 +1,67 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testSection is a section of an export file built by writeTestExportV5.
+type testSection struct {
+	descriptor ExportSection
+	data       []byte
+}
+
+// writeTestExportV5 builds a v5 export file with the given sections, which may be unknown to the reader.
+func writeTestExportV5(t *testing.T, sections []testSection) []byte {
+	msHashBytes, err := hashToBytes(strings.Repeat("9", 81))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	h := sha256.New()
+	mw := io.MultiWriter(&buf, h)
+	values := []interface{}{exportFileMagic, ExportFileVersion, uint8(0), msHashBytes, int32(1), int64(2), uint8(len(sections))}
+	for _, section := range sections {
+		d := section.descriptor
+		values = append(values, d.Type, d.Encoding, d.Count, d.ByteLength)
+	}
+	for _, v := range values {
+		if err := binary.Write(mw, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, section := range sections {
+		sectionHash := sha256.Sum256(section.data)
+		mw.Write(section.data)
+		mw.Write(sectionHash[:])
+	}
+	buf.Write(h.Sum(nil))
+	return buf.Bytes()
+}
+
+func TestStreamExportSkipsUnknownSectionWithoutEntries(t *testing.T) {
+	addr, err := hashToBytes(strings.Repeat("A", 81))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := writeTestExportV5(t, []testSection{
+		{descriptor: ExportSection{Type: 0x7F, Count: 0, ByteLength: 5}, data: []byte("extra")},
+		{descriptor: newExportSection(SectionSpentAddresses, 1), data: addr},
+	})
+
+	var spentAddrs [][]byte
+	if _, err := StreamExport(bytes.NewReader(file), ExportConsumer{SpentAddress: func(a []byte) error {
+		spentAddrs = append(spentAddrs, append([]byte{}, a...))
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(spentAddrs) != 1 || !bytes.Equal(spentAddrs[0], addr) {
+		t.Fatalf("expected the spent address following the unknown section, got %x", spentAddrs)
+	}
+}
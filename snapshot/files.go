@@ -0,0 +1,347 @@
+package snapshot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/pkg/errors"
+)
+
+// FilesOptions defines the IRI local snapshot files to read a snapshot from.
+type FilesOptions struct {
+	// MetaFile is the path to the file containing the local snapshot meta data, i.e. mainnet.snapshot.meta.
+	MetaFile string
+	// StateFile is the path to the file containing the local snapshot state data, i.e. mainnet.snapshot.state.
+	StateFile string
+	// OnInvalidTrytes handles solid entry point, seen milestone and ledger entry lines containing invalid hashes or addresses.
+	// If nil, reading fails with an ErrInvalidTrytes on the first invalid line.
+	OnInvalidTrytes InvalidTrytesHandler
+}
+
+// ReadFromFiles reads a snapshot from the IRI local snapshot files defined by the given options.
+func ReadFromFiles(opts FilesOptions) (*Snapshot, error) {
+	s, _, err := ReadFromFilesWithCounts(opts)
+	return s, err
+}
+
+// ReadFromFilesWithCounts reads a snapshot from the IRI local snapshot files defined by the given options
+// and additionally returns the counts declared by them, see ReadFilesWithCounts.
+func ReadFromFilesWithCounts(opts FilesOptions) (*Snapshot, *Counts, error) {
+	metaFile, err := os.Open(opts.MetaFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer metaFile.Close()
+
+	stateFile, err := os.Open(opts.StateFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer stateFile.Close()
+
+	return readFiles(metaFile, opts.MetaFile, stateFile, opts.StateFile, opts.OnInvalidTrytes)
+}
+
+// ReadFiles reads a snapshot from the content of IRI's local snapshot meta and state files.
+func ReadFiles(meta io.Reader, state io.Reader) (*Snapshot, error) {
+	s, _, err := ReadFilesWithCounts(meta, state)
+	return s, err
+}
+
+// ReadFilesWithCounts reads a snapshot from the content of IRI's local snapshot meta and state files
+// and additionally returns the solid entry points and seen milestones counts declared by the meta file
+// and the amount of lines of the state file as ledger entries count. As entries with duplicate hashes
+// overwrite each other, the counts may exceed the amount of entries of the snapshot.
+//
+// The files are parsed strictly: the meta file must contain exactly the declared amount of solid entry point
+// and seen milestone lines and every line must be well-formed, otherwise an ErrMalformed is returned.
+// CRLF line endings and blank trailing lines are accepted. Every hash and address is validated via ParseHash,
+// an ErrInvalidTrytes is returned for the first invalid one.
+func ReadFilesWithCounts(meta io.Reader, state io.Reader) (*Snapshot, *Counts, error) {
+	return readFiles(meta, "meta file", state, "state file", nil)
+}
+
+func readFiles(meta io.Reader, metaSource string, state io.Reader, stateSource string, onInvalid InvalidTrytesHandler) (*Snapshot, *Counts, error) {
+	s := New()
+
+	metaScanner := newLineScanner(meta, metaSource)
+	headerLine := func(field string) (string, error) {
+		line, ok, err := metaScanner.next()
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", metaScanner.errorf("unexpected end of file, expected the %s", field)
+		}
+		return line, nil
+	}
+
+	msHash, err := headerLine("milestone hash")
+	if err != nil {
+		return nil, nil, err
+	}
+	// the milestone hash can not be skipped
+	if s.MilestoneHash, _, err = metaScanner.parseHash(msHash, nil); err != nil {
+		return nil, nil, err
+	}
+
+	msIndexStr, err := headerLine("milestone index")
+	if err != nil {
+		return nil, nil, err
+	}
+	msIndex, err := strconv.ParseInt(msIndexStr, 10, 32)
+	if err != nil {
+		return nil, nil, metaScanner.wrap(err, "invalid milestone index")
+	}
+	s.MilestoneIndex = int32(msIndex)
+
+	msTimestampStr, err := headerLine("milestone timestamp")
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.MilestoneTimestamp, err = strconv.ParseInt(msTimestampStr, 10, 64); err != nil {
+		return nil, nil, metaScanner.wrap(err, "invalid milestone timestamp")
+	}
+
+	counts := &Counts{}
+	for _, c := range []struct {
+		field string
+		count *int32
+	}{
+		{"solid entry points count", &counts.SolidEntryPoints},
+		{"seen milestones count", &counts.SeenMilestones},
+	} {
+		countStr, err := headerLine(c.field)
+		if err != nil {
+			return nil, nil, err
+		}
+		count, err := strconv.ParseInt(countStr, 10, 32)
+		if err != nil {
+			return nil, nil, metaScanner.wrap(err, "invalid "+c.field)
+		}
+		if count < 0 {
+			return nil, nil, metaScanner.errorf("negative %s %d", c.field, count)
+		}
+		*c.count = int32(count)
+	}
+
+	for _, section := range []struct {
+		name    string
+		count   int32
+		entries map[trinary.Hash]int32
+	}{
+		{"solid entry point", counts.SolidEntryPoints, s.SolidEntryPoints},
+		{"seen milestone", counts.SeenMilestones, s.SeenMilestones},
+	} {
+		for i := int32(0); i < section.count; i++ {
+			line, ok, err := metaScanner.next()
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				return nil, nil, metaScanner.errorf("unexpected end of file, expected %d %s lines but found %d", section.count, section.name, i)
+			}
+			hash, indexStr, err := metaScanner.split(line)
+			if err != nil {
+				return nil, nil, err
+			}
+			hash, ok, err = metaScanner.parseHash(hash, onInvalid)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				continue
+			}
+			index, err := strconv.ParseInt(indexStr, 10, 32)
+			if err != nil {
+				return nil, nil, metaScanner.wrap(err, "invalid "+section.name+" index")
+			}
+			section.entries[hash] = int32(index)
+		}
+	}
+
+	if _, ok, err := metaScanner.next(); err != nil {
+		return nil, nil, err
+	} else if ok {
+		return nil, nil, metaScanner.errorf("unexpected line, the declared %d solid entry points and %d seen milestones were already read",
+			counts.SolidEntryPoints, counts.SeenMilestones)
+	}
+
+	counts.LedgerEntries, err = readState(newLineScanner(state, stateSource), s.LedgerState, onInvalid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s, counts, nil
+}
+
+// ReadState reads the ledger state from the content of IRI's local snapshot state file.
+func ReadState(state io.Reader, opts TextOptions) (map[trinary.Hash]uint64, error) {
+	source := opts.Source
+	if source == "" {
+		source = "state file"
+	}
+	ledger := make(map[trinary.Hash]uint64)
+	if _, err := readState(newLineScanner(state, source), ledger, opts.OnInvalidTrytes); err != nil {
+		return nil, err
+	}
+	return ledger, nil
+}
+
+// readState reads the address;balance lines of a state file into the given ledger and returns the amount of lines,
+// including skipped ones.
+func readState(stateScanner *lineScanner, ledger map[trinary.Hash]uint64, onInvalid InvalidTrytesHandler) (int32, error) {
+	var lines int32
+	for {
+		line, ok, err := stateScanner.next()
+		if err != nil || !ok {
+			return lines, err
+		}
+		addr, balanceStr, err := stateScanner.split(line)
+		if err != nil {
+			return lines, err
+		}
+		lines++
+		addr, ok, err = stateScanner.parseHash(addr, onInvalid)
+		if err != nil {
+			return lines, err
+		}
+		if !ok {
+			continue
+		}
+		balance, err := strconv.ParseUint(balanceStr, 10, 64)
+		if err != nil {
+			return lines, stateScanner.wrap(err, "invalid balance")
+		}
+		ledger[addr] = balance
+	}
+}
+
+// lineScanner scans the lines of a text file, keeping track of the line number for error reporting.
+// Line endings may be LF or CRLF and blank lines are only accepted at the end of the file.
+type lineScanner struct {
+	scanner *bufio.Scanner
+	source  string
+	line    int
+	// blankLine is the number of the first blank line of a sequence of blank lines.
+	blankLine int
+}
+
+func newLineScanner(r io.Reader, source string) *lineScanner {
+	return &lineScanner{scanner: bufio.NewScanner(r), source: source}
+}
+
+// next returns the next line or false if the end of the file was reached.
+func (ls *lineScanner) next() (string, bool, error) {
+	for ls.scanner.Scan() {
+		ls.line++
+		line := strings.TrimSuffix(ls.scanner.Text(), "\r")
+		if line == "" {
+			if ls.blankLine == 0 {
+				ls.blankLine = ls.line
+			}
+			continue
+		}
+		if ls.blankLine != 0 {
+			return "", false, &ErrMalformed{Source: ls.source, Line: ls.blankLine, Err: errors.New("blank line within data")}
+		}
+		return line, true, nil
+	}
+	if err := ls.scanner.Err(); err != nil {
+		return "", false, ls.wrap(err, "could not read line")
+	}
+	return "", false, nil
+}
+
+// split splits the given line into its hash and value.
+func (ls *lineScanner) split(line string) (string, string, error) {
+	split := strings.Split(line, ";")
+	if len(split) != 2 || split[0] == "" {
+		return "", "", ls.errorf("malformed line '%s', expected hash;value", line)
+	}
+	return split[0], split[1], nil
+}
+
+// parseHash validates the given hash or address of the current line via ParseHash.
+// If it is invalid, the given handler decides whether the line is skipped, in which case false is returned.
+func (ls *lineScanner) parseHash(hash string, onInvalid InvalidTrytesHandler) (trinary.Hash, bool, error) {
+	parsed, err := ParseHash(hash)
+	if err == nil {
+		return parsed, true, nil
+	}
+	invalid := &ErrInvalidTrytes{Source: ls.source, Line: ls.line, Err: err}
+	if onInvalid == nil {
+		return "", false, invalid
+	}
+	return "", false, onInvalid(invalid)
+}
+
+func (ls *lineScanner) errorf(format string, args ...interface{}) error {
+	return &ErrMalformed{Source: ls.source, Line: ls.line, Err: errors.Errorf(format, args...)}
+}
+
+func (ls *lineScanner) wrap(err error, message string) error {
+	return &ErrMalformed{Source: ls.source, Line: ls.line, Err: errors.Wrap(err, message)}
+}
+
+// WriteToFiles writes the given snapshot into the IRI local snapshot files defined by the given options.
+// Existing files are overwritten.
+func WriteToFiles(opts FilesOptions, s *Snapshot) error {
+	metaFile, err := os.OpenFile(opts.MetaFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+	defer metaFile.Close()
+
+	stateFile, err := os.OpenFile(opts.StateFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+	defer stateFile.Close()
+
+	metaWriter := bufio.NewWriter(metaFile)
+	stateWriter := bufio.NewWriter(stateFile)
+	if err := WriteFiles(metaWriter, stateWriter, s); err != nil {
+		return err
+	}
+	if err := metaWriter.Flush(); err != nil {
+		return err
+	}
+	if err := stateWriter.Flush(); err != nil {
+		return err
+	}
+	if err := metaFile.Close(); err != nil {
+		return err
+	}
+	return stateFile.Close()
+}
+
+// WriteFiles writes the given snapshot in the format of IRI's local snapshot meta and state files.
+// The meta file contains the milestone hash, index and timestamp, the solid entry points and seen milestones counts
+// followed by their hash;index lines, the state file contains address;balance lines.
+// Lines are written in ascending order of their hashes.
+func WriteFiles(meta io.Writer, state io.Writer, s *Snapshot) error {
+	if _, err := fmt.Fprintf(meta, "%s\n%d\n%d\n%d\n%d\n", s.MilestoneHash, s.MilestoneIndex, s.MilestoneTimestamp,
+		len(s.SolidEntryPoints), len(s.SeenMilestones)); err != nil {
+		return err
+	}
+	for _, entries := range []map[trinary.Hash]int32{s.SolidEntryPoints, s.SeenMilestones} {
+		for _, hash := range sortedIndexHashes(entries) {
+			if _, err := fmt.Fprintf(meta, "%s;%d\n", hash, entries[hash]); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, addr := range sortedBalanceAddresses(s.LedgerState) {
+		if _, err := fmt.Fprintf(state, "%s;%d\n", addr, s.LedgerState[addr]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package snapshot
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/iotaledger/iota.go/trinary"
+)
+
+// FundedSpentAddress is an address with a non-zero balance which was already spent from.
+type FundedSpentAddress struct {
+	Address trinary.Hash `json:"address"`
+	Balance uint64       `json:"balance"`
+}
+
+// FundedSpentReport lists the funded addresses which were already spent from. As spending from an address
+// reveals parts of its private key, their funds are at risk once the key is reused.
+type FundedSpentReport struct {
+	Source               string `json:"source"`
+	SpentAddressesSource string `json:"spentAddressesSource"`
+	// SpentAddresses is the amount of spent addresses which were checked.
+	SpentAddresses int `json:"spentAddresses"`
+	// Addresses are the funded spent addresses in ascending order.
+	Addresses []FundedSpentAddress `json:"addresses"`
+	// Total is the sum of the balances of the funded spent addresses.
+	Total *big.Int `json:"total"`
+}
+
+// FindFundedSpentAddresses reports the ledger entries with a non-zero balance whose address is streamed
+// in its byte encoding by the given spent addresses stream. Only the funded addresses are held in memory,
+// the spent addresses are looked up one by one while they are streamed.
+func FindFundedSpentAddresses(ledger map[trinary.Hash]uint64, spentAddresses func(fn func(addr []byte) error) error) (*FundedSpentReport, error) {
+	funded := make(map[string]trinary.Hash)
+	for addr, balance := range ledger {
+		if balance == 0 {
+			continue
+		}
+		// addresses which are no valid hashes can not have been spent from
+		if !isHash(addr) {
+			continue
+		}
+		addrBytes, err := hashToBytes(addr)
+		if err != nil {
+			continue
+		}
+		funded[string(addrBytes)] = addr
+	}
+
+	report := &FundedSpentReport{Addresses: []FundedSpentAddress{}, Total: new(big.Int)}
+	if err := spentAddresses(func(addr []byte) error {
+		report.SpentAddresses++
+		fundedAddr, has := funded[string(addr)]
+		if !has {
+			return nil
+		}
+		// the same spent address may be streamed more than once
+		delete(funded, string(addr))
+		report.Addresses = append(report.Addresses, FundedSpentAddress{Address: fundedAddr, Balance: ledger[fundedAddr]})
+		report.Total.Add(report.Total, new(big.Int).SetUint64(ledger[fundedAddr]))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(report.Addresses, func(i, j int) bool {
+		return report.Addresses[i].Address < report.Addresses[j].Address
+	})
+	return report, nil
+}
@@ -0,0 +1,48 @@
+package snapshot
+
+import (
+	"github.com/pkg/errors"
+)
+
+// IOTASupply is the total supply of the IOTA mainnet, devnet and comnet.
+const IOTASupply uint64 = 2779530283277761
+
+// Network is a profile of a network defining the expectations towards its snapshots.
+type Network struct {
+	// Name identifies the network and is recorded in export files.
+	Name string
+	// Supply is the expected total supply of the ledger.
+	Supply uint64
+	// FilePrefix is the default prefix of the network's local snapshot files, i.e. "mainnet" for mainnet.snapshot.meta.
+	FilePrefix string
+	// CheckSupply defines whether the total supply of the ledger must equal Supply.
+	CheckSupply bool
+}
+
+// the names of the predefined networks.
+const (
+	NetworkMainnet = "mainnet"
+	NetworkDevnet  = "devnet"
+	NetworkComnet  = "comnet"
+	NetworkCustom  = "custom"
+)
+
+// Networks are the predefined network profiles by their names. The custom profile
+// is meant for private tangles, whose supply is only checked if it is set.
+var Networks = map[string]Network{
+	NetworkMainnet: {Name: NetworkMainnet, Supply: IOTASupply, FilePrefix: "mainnet", CheckSupply: true},
+	// IRI uses the testnet prefix for its local snapshot files on the devnet
+	NetworkDevnet: {Name: NetworkDevnet, Supply: IOTASupply, FilePrefix: "testnet", CheckSupply: true},
+	NetworkComnet: {Name: NetworkComnet, Supply: IOTASupply, FilePrefix: "comnet", CheckSupply: true},
+	// private tangles usually keep IRI's default prefix
+	NetworkCustom: {Name: NetworkCustom, FilePrefix: "mainnet"},
+}
+
+// NetworkByName returns a copy of the predefined network profile with the given name.
+func NetworkByName(name string) (Network, error) {
+	network, has := Networks[name]
+	if !has {
+		return Network{}, errors.Errorf("unknown network '%s', expected one of %s, %s, %s or %s", name, NetworkMainnet, NetworkDevnet, NetworkComnet, NetworkCustom)
+	}
+	return network, nil
+}
@@ -0,0 +1,76 @@
+// Package snapshot reads and writes IRI local snapshots in the different representations
+// used by IRI and this tool: the meta/state files, the binary blob persisted in a localsnapshots-db
+// and the export file.
+package snapshot
+
+import (
+	"sort"
+
+	"github.com/iotaledger/iota.go/trinary"
+)
+
+const (
+	// HashTrytesSize is the amount of trytes of a hash or address.
+	HashTrytesSize = 81
+	// HashBytesSize is the amount of bytes of a hash or address encoded via trinary.TrytesToBytes.
+	HashBytesSize = 49
+)
+
+// Snapshot is an IRI local snapshot.
+type Snapshot struct {
+	MilestoneHash      trinary.Hash
+	MilestoneIndex     int32
+	MilestoneTimestamp int64
+	SolidEntryPoints   map[trinary.Hash]int32
+	SeenMilestones     map[trinary.Hash]int32
+	LedgerState        map[trinary.Hash]uint64
+}
+
+// New creates a new empty Snapshot.
+func New() *Snapshot {
+	return &Snapshot{
+		SolidEntryPoints: make(map[trinary.Hash]int32),
+		SeenMilestones:   make(map[trinary.Hash]int32),
+		LedgerState:      make(map[trinary.Hash]uint64),
+	}
+}
+
+// SizeInBytes returns the size of the snapshot's binary representation.
+func (s *Snapshot) SizeInBytes() int {
+	return HashBytesSize + 20 +
+		(len(s.SolidEntryPoints) * (HashBytesSize + 4)) +
+		(len(s.SeenMilestones) * (HashBytesSize + 4)) +
+		(len(s.LedgerState) * (HashBytesSize + 8))
+}
+
+func hashToBytes(hash trinary.Hash) ([]byte, error) {
+	return trinary.TrytesToBytes(hash)
+}
+
+func bytesToHash(hashBytes []byte) (trinary.Hash, error) {
+	hash, err := trinary.BytesToTrytes(hashBytes)
+	if err != nil {
+		return "", err
+	}
+	return hash[:HashTrytesSize], nil
+}
+
+// sortedIndexHashes returns the hashes of the given hash to milestone index entries in ascending order.
+func sortedIndexHashes(entries map[trinary.Hash]int32) []trinary.Hash {
+	hashes := make([]trinary.Hash, 0, len(entries))
+	for hash := range entries {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// sortedBalanceAddresses returns the addresses of the given address to balance entries in ascending order.
+func sortedBalanceAddresses(entries map[trinary.Hash]uint64) []trinary.Hash {
+	addrs := make([]trinary.Hash, 0, len(entries))
+	for addr := range entries {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
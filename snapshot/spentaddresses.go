@@ -0,0 +1,50 @@
+package snapshot
+
+import (
+	"bytes"
+	"io"
+	"sort"
+)
+
+// ReadSpentAddressesText reads the spent addresses of a text source containing one address per line,
+// i.e. previousEpochsSpentAddresses.txt, and passes them in their byte encoding to the given function.
+// Every address is validated via ParseHash, invalid ones are handled as defined by the given options.
+func ReadSpentAddressesText(r io.Reader, opts TextOptions, fn func(addr []byte) error) error {
+	scanner := newLineScanner(r, opts.Source)
+	for {
+		line, ok, err := scanner.next()
+		if err != nil || !ok {
+			return err
+		}
+		addr, ok, err := scanner.parseHash(line, opts.OnInvalidTrytes)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		addrBytes, err := hashToBytes(addr)
+		if err != nil {
+			return &ErrInvalidTrytes{Source: opts.Source, Line: scanner.line, Err: err}
+		}
+		if err := fn(addrBytes); err != nil {
+			return err
+		}
+	}
+}
+
+// SortSpentAddresses sorts the given spent addresses in their byte encoding in ascending order,
+// as required by WriteExport, and removes duplicates. The sorted addresses are returned.
+func SortSpentAddresses(addrs [][]byte) [][]byte {
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i], addrs[j]) < 0
+	})
+	unique := addrs[:0]
+	for i, addr := range addrs {
+		if i > 0 && bytes.Equal(addr, addrs[i-1]) {
+			continue
+		}
+		unique = append(unique, addr)
+	}
+	return unique
+}
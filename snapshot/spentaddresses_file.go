@@ -0,0 +1,132 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// SpentAddressesFileVersion is the latest version of the spent addresses file format (spent_addresses.bin).
+// v1 files consist of an int32 count followed by the raw spent addresses without any version or checksum.
+const SpentAddressesFileVersion byte = 2
+
+// spentAddressesFileMagic is the prefix of v2 and later spent addresses files.
+var spentAddressesFileMagic = []byte("ISAF")
+
+// SpentAddressesFileHeader is the header of a spent addresses file.
+type SpentAddressesFileHeader struct {
+	// Version is the version of the spent addresses file format.
+	Version byte
+	// Count is the amount of spent addresses within the file.
+	Count int32
+	// TrailingBytes is the amount of bytes following the declared spent addresses of a v1 file,
+	// i.e. left over from a previously written longer file.
+	TrailingBytes int64
+}
+
+// HasChecksum defines whether the spent addresses file version contains a trailing sha256 hash.
+func (h *SpentAddressesFileHeader) HasChecksum() bool {
+	return h.Version >= 2
+}
+
+// WriteSpentAddressesFile writes the given amount of spent addresses passed by the given stream function
+// as a spent addresses file to the given writer. The spent addresses must be passed in strictly ascending byte order.
+// It returns the sha256 hash of the written data.
+//
+//	magic -> 4 bytes ("ISAF")
+//	versionByte -> 1 byte
+//	amountOfSpentAddresses -> int32
+//	amountOfSpentAddresses * spentAddress -> 49 bytes
+//	sha256 hash of the data above -> 32 bytes
+func WriteSpentAddressesFile(w io.Writer, count int32, stream func(fn func(addr []byte) error) error) ([sha256.Size]byte, error) {
+	var sha256Hash [sha256.Size]byte
+
+	h := sha256.New()
+	mw := io.MultiWriter(w, h)
+
+	for _, v := range []interface{}{spentAddressesFileMagic, SpentAddressesFileVersion, count} {
+		if err := binary.Write(mw, binary.LittleEndian, v); err != nil {
+			return sha256Hash, err
+		}
+	}
+
+	if err := writeSpentAddresses(mw, ExportOptions{SpentAddressesCount: count, SpentAddresses: stream}); err != nil {
+		return sha256Hash, err
+	}
+
+	copy(sha256Hash[:], h.Sum(nil))
+	if _, err := w.Write(sha256Hash[:]); err != nil {
+		return sha256Hash, err
+	}
+	return sha256Hash, nil
+}
+
+// StreamSpentAddressesFile reads a spent addresses file of any version from the given reader and passes
+// the spent addresses one by one to the given function. The passed slice is only valid for the duration of the call.
+// The sha256 hash is verified once the file was read completely and returned, it is zero for v1 files.
+func StreamSpentAddressesFile(r io.Reader, fn func(addr []byte) error) (*SpentAddressesFileHeader, [sha256.Size]byte, error) {
+	var fileHash [sha256.Size]byte
+	header := &SpentAddressesFileHeader{Version: 1}
+
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(spentAddressesFileMagic))
+	if err != nil && err != io.EOF {
+		return nil, fileHash, err
+	}
+
+	h := sha256.New()
+	or := &offsetReader{r: br}
+	tr := io.TeeReader(or, h)
+
+	if bytes.Equal(magic, spentAddressesFileMagic) {
+		if _, err := io.ReadFull(tr, make([]byte, len(spentAddressesFileMagic))); err != nil {
+			return nil, fileHash, or.truncated(err, "header")
+		}
+		if err := binary.Read(tr, binary.LittleEndian, &header.Version); err != nil {
+			return nil, fileHash, or.truncated(err, "header")
+		}
+		if header.Version != SpentAddressesFileVersion {
+			return nil, fileHash, errors.Wrapf(ErrUnsupportedVersion, "spent addresses file version %d is not supported", header.Version)
+		}
+	}
+
+	if err := binary.Read(tr, binary.LittleEndian, &header.Count); err != nil {
+		return nil, fileHash, or.truncated(err, "header")
+	}
+	if header.Count < 0 {
+		return nil, fileHash, errors.Errorf("invalid spent addresses count %d", header.Count)
+	}
+
+	addrBuf := make([]byte, HashBytesSize)
+	for i := 0; i < int(header.Count); i++ {
+		if _, err := io.ReadFull(tr, addrBuf); err != nil {
+			return nil, fileHash, or.truncated(err, "spent addresses")
+		}
+		if err := fn(addrBuf); err != nil {
+			return nil, fileHash, err
+		}
+	}
+
+	if !header.HasChecksum() {
+		header.TrailingBytes, err = io.Copy(ioutil.Discard, or)
+		return header, fileHash, err
+	}
+
+	// the trailing hash is not part of the hashed data
+	if _, err := io.ReadFull(or, fileHash[:]); err != nil {
+		return nil, fileHash, or.truncated(err, "checksum")
+	}
+	computedHash := h.Sum(nil)
+	if !bytes.Equal(fileHash[:], computedHash) {
+		return nil, fileHash, errors.Wrapf(ErrChecksumMismatch, "computed and sha256 hash do not match: %x (file) vs. %x (computed)", fileHash, computedHash)
+	}
+	if n, _ := io.Copy(ioutil.Discard, or); n > 0 {
+		return nil, fileHash, errors.Errorf("unexpected %d bytes following the checksum", n)
+	}
+	return header, fileHash, nil
+}
@@ -0,0 +1,283 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// mergeChunkSize is the amount of spent addresses handed over at once from a stream to the merge.
+const mergeChunkSize = 1024
+
+// errMergeAborted stops the streams of a merge which ended early.
+var errMergeAborted = errors.New("merge aborted")
+
+// SpentAddressesMergeCount holds the amounts of spent addresses a stream contributed to a merge.
+type SpentAddressesMergeCount struct {
+	// Read is the amount of spent addresses passed by the stream.
+	Read int
+	// Added is the amount of spent addresses not passed by any preceding stream.
+	Added int
+}
+
+// MergeSpentAddresses merges the spent addresses passed by the given stream functions, each of which must pass them
+// in strictly ascending byte order, and passes every distinct spent address once in ascending byte order to the given function.
+// The passed slice is only valid for the duration of the call. Only a bounded amount of spent addresses per stream
+// is held in memory, as the streams run concurrently. It returns the counts of every stream.
+func MergeSpentAddresses(streams []func(fn func(addr []byte) error) error, fn func(addr []byte) error) ([]SpentAddressesMergeCount, error) {
+	counts := make([]SpentAddressesMergeCount, len(streams))
+	err := mergeSpentAddresses(streams, func(addr []byte, index int, first bool) error {
+		counts[index].Read++
+		if !first {
+			return nil
+		}
+		// the stream with the lowest index containing a spent address adds it
+		counts[index].Added++
+		return fn(addr)
+	})
+	return counts, err
+}
+
+// mergeSpentAddresses merges the spent addresses passed by the given stream functions, each of which must pass them
+// in strictly ascending byte order, and passes every spent address of every stream in ascending byte order and ascending
+// stream index to the given visit function, together with the index of its stream and whether it is the first occurrence
+// of the spent address. The passed slice is only valid for the duration of the call.
+func mergeSpentAddresses(streams []func(fn func(addr []byte) error) error, visit func(addr []byte, index int, first bool) error) error {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	cursors := make(mergeCursors, 0, len(streams))
+	for i, stream := range streams {
+		c := &mergeCursor{index: i, chunks: make(chan []byte, 1)}
+		wg.Add(1)
+		go func(stream func(fn func(addr []byte) error) error) {
+			defer wg.Done()
+			c.produce(stream, done)
+		}(stream)
+		ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			cursors = append(cursors, c)
+		}
+	}
+	heap.Init(&cursors)
+
+	var last []byte
+	for len(cursors) > 0 {
+		c := cursors[0]
+		c.position++
+		first := last == nil || !bytes.Equal(last, c.addr)
+		if first {
+			last = append(last[:0], c.addr...)
+		}
+		if err := visit(c.addr, c.index, first); err != nil {
+			return err
+		}
+
+		prev := c.addr
+		ok, err := c.next()
+		switch {
+		case err != nil:
+			return err
+		case !ok:
+			heap.Pop(&cursors)
+			continue
+		case bytes.Compare(prev, c.addr) >= 0:
+			return errors.Wrapf(ErrSpentAddressesNotSorted, "spent address %d of stream %d is not greater than its predecessor",
+				c.position, c.index)
+		}
+		heap.Fix(&cursors, 0)
+	}
+	return nil
+}
+
+// mergeCursor is the position of a merge within the spent addresses of a stream.
+type mergeCursor struct {
+	index int
+	// position is the amount of spent addresses of the stream merged so far.
+	position int
+	chunks   chan []byte
+	// err is the error of the stream, set before chunks is closed.
+	err   error
+	chunk []byte
+	addr  []byte
+}
+
+// produce passes the spent addresses of the given stream in chunks to the cursor until the stream ends or done is closed.
+func (c *mergeCursor) produce(stream func(fn func(addr []byte) error) error, done <-chan struct{}) {
+	defer close(c.chunks)
+	chunk := make([]byte, 0, mergeChunkSize*HashBytesSize)
+	send := func() bool {
+		select {
+		case c.chunks <- chunk:
+			chunk = make([]byte, 0, mergeChunkSize*HashBytesSize)
+			return true
+		case <-done:
+			return false
+		}
+	}
+	c.err = stream(func(addr []byte) error {
+		if len(addr) != HashBytesSize {
+			return errors.Errorf("invalid spent address length %d, expected %d", len(addr), HashBytesSize)
+		}
+		chunk = append(chunk, addr...)
+		if len(chunk) < cap(chunk) || send() {
+			return nil
+		}
+		return errMergeAborted
+	})
+	if c.err == nil && len(chunk) > 0 {
+		send()
+	}
+}
+
+// next moves the cursor to the next spent address and returns false if there is none.
+func (c *mergeCursor) next() (bool, error) {
+	if len(c.chunk) == 0 {
+		chunk, ok := <-c.chunks
+		if !ok {
+			c.addr = nil
+			return false, c.err
+		}
+		c.chunk = chunk
+	}
+	c.addr, c.chunk = c.chunk[:HashBytesSize], c.chunk[HashBytesSize:]
+	return true, nil
+}
+
+// mergeCursors is a heap of cursors ordered by their current spent address and their index.
+type mergeCursors []*mergeCursor
+
+func (h mergeCursors) Len() int { return len(h) }
+func (h mergeCursors) Less(i, j int) bool {
+	if cmp := bytes.Compare(h[i].addr, h[j].addr); cmp != 0 {
+		return cmp < 0
+	}
+	return h[i].index < h[j].index
+}
+func (h mergeCursors) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeCursors) Push(x interface{}) { *h = append(*h, x.(*mergeCursor)) }
+func (h *mergeCursors) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// SpentAddressesSorter sorts more spent addresses than fit into memory by writing them in sorted runs
+// of a fixed size into files within a folder. The runs are then combined using MergeSpentAddresses.
+type SpentAddressesSorter struct {
+	dir     string
+	runSize int
+	buf     []byte
+	runs    []string
+}
+
+// NewSpentAddressesSorter creates a SpentAddressesSorter holding up to the given amount of spent addresses in memory
+// and writing its runs into the given existing folder, which may be shared by several sorters and is removed by the caller.
+func NewSpentAddressesSorter(dir string, runSize int) *SpentAddressesSorter {
+	return &SpentAddressesSorter{dir: dir, runSize: runSize}
+}
+
+// Add adds a copy of the given spent address.
+func (s *SpentAddressesSorter) Add(addr []byte) error {
+	if len(addr) != HashBytesSize {
+		return errors.Errorf("invalid spent address length %d, expected %d", len(addr), HashBytesSize)
+	}
+	if s.buf == nil {
+		s.buf = make([]byte, 0, s.runSize*HashBytesSize)
+	}
+	s.buf = append(s.buf, addr...)
+	if len(s.buf) < cap(s.buf) {
+		return nil
+	}
+	return s.writeRun()
+}
+
+// writeRun sorts the buffered spent addresses and writes them without duplicates into a new run file.
+func (s *SpentAddressesSorter) writeRun() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	addrs := make([][]byte, 0, len(s.buf)/HashBytesSize)
+	for i := 0; i < len(s.buf); i += HashBytesSize {
+		addrs = append(addrs, s.buf[i:i+HashBytesSize])
+	}
+	// duplicates reside next to each other after sorting
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i], addrs[j]) < 0
+	})
+
+	f, err := ioutil.TempFile(s.dir, "run-")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for i, addr := range addrs {
+		if i > 0 && bytes.Equal(addr, addrs[i-1]) {
+			continue
+		}
+		if _, err := w.Write(addr); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f.Name())
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// Runs writes the remaining spent addresses and returns a stream function for every run,
+// each passing the spent addresses of its run in strictly ascending byte order.
+func (s *SpentAddressesSorter) Runs() ([]func(fn func(addr []byte) error) error, error) {
+	if err := s.writeRun(); err != nil {
+		return nil, err
+	}
+	// the buffer is not needed anymore while the runs are merged
+	s.buf = nil
+
+	streams := make([]func(fn func(addr []byte) error) error, len(s.runs))
+	for i, name := range s.runs {
+		name := name
+		streams[i] = func(fn func(addr []byte) error) error {
+			f, err := os.OpenFile(name, os.O_RDONLY, 0666)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r := bufio.NewReader(f)
+			addr := make([]byte, HashBytesSize)
+			for {
+				if _, err := io.ReadFull(r, addr); err != nil {
+					if err == io.EOF {
+						return nil
+					}
+					return errors.Wrapf(err, "could not read run %s", name)
+				}
+				if err := fn(addr); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return streams, nil
+}
@@ -0,0 +1,160 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// testAddr returns a spent address consisting of the given byte, addresses compare like their bytes.
+func testAddr(b byte) []byte {
+	return bytes.Repeat([]byte{b}, HashBytesSize)
+}
+
+// testStream returns a stream function passing the spent addresses consisting of the given bytes.
+func testStream(bs ...byte) func(fn func(addr []byte) error) error {
+	return func(fn func(addr []byte) error) error {
+		for _, b := range bs {
+			if err := fn(testAddr(b)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// collectSpentAddresses returns a function appending copies of the passed spent addresses to addrs.
+func collectSpentAddresses(addrs *[][]byte) func(addr []byte) error {
+	return func(addr []byte) error {
+		*addrs = append(*addrs, append([]byte{}, addr...))
+		return nil
+	}
+}
+
+// expectSpentAddresses fails the test if addrs does not consist of the spent addresses of the given bytes.
+func expectSpentAddresses(t *testing.T, addrs [][]byte, bs ...byte) {
+	t.Helper()
+	if len(addrs) != len(bs) {
+		t.Fatalf("expected %d spent addresses, got %d", len(bs), len(addrs))
+	}
+	for i, b := range bs {
+		if !bytes.Equal(addrs[i], testAddr(b)) {
+			t.Fatalf("expected spent address %d to consist of %d, got %x", i, b, addrs[i][0])
+		}
+	}
+}
+
+func TestMergeSpentAddressesDuplicatesAcrossStreams(t *testing.T) {
+	var addrs [][]byte
+	counts, err := MergeSpentAddresses([]func(fn func(addr []byte) error) error{
+		testStream(1, 3, 5),
+		testStream(2, 3, 4, 5),
+		testStream(),
+		testStream(1, 6),
+	}, collectSpentAddresses(&addrs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSpentAddresses(t, addrs, 1, 2, 3, 4, 5, 6)
+
+	expected := []SpentAddressesMergeCount{{Read: 3, Added: 3}, {Read: 4, Added: 2}, {}, {Read: 2, Added: 1}}
+	for i, c := range counts {
+		if c != expected[i] {
+			t.Fatalf("expected the counts %+v of stream %d, got %+v", expected[i], i, c)
+		}
+	}
+}
+
+func TestMergeSpentAddressesNotSorted(t *testing.T) {
+	for _, stream := range []func(fn func(addr []byte) error) error{testStream(1, 3, 2), testStream(1, 2, 2)} {
+		_, err := MergeSpentAddresses([]func(fn func(addr []byte) error) error{testStream(1, 2, 3), stream}, func([]byte) error {
+			return nil
+		})
+		if errors.Cause(err) != ErrSpentAddressesNotSorted {
+			t.Fatalf("expected %v, got %v", ErrSpentAddressesNotSorted, err)
+		}
+	}
+}
+
+func TestMergeSpentAddressesAbortStopsStreams(t *testing.T) {
+	// the streams are longer than the chunks buffered by the merge, so they block until the merge ends
+	var streams []func(fn func(addr []byte) error) error
+	for i := 0; i < 4; i++ {
+		streams = append(streams, func(fn func(addr []byte) error) error {
+			addr := make([]byte, HashBytesSize)
+			for j := uint32(0); j < 10*mergeChunkSize; j++ {
+				binary.BigEndian.PutUint32(addr, j)
+				if err := fn(addr); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	before := runtime.NumGoroutine()
+	errStop := errors.New("stop")
+	var merged int
+	_, err := MergeSpentAddresses(streams, func([]byte) error {
+		merged++
+		if merged == 10 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("expected %v, got %v", errStop, err)
+	}
+
+	// the goroutines of the streams have ended once the merge returned
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Fatalf("expected %d goroutines after the merge was aborted, got %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSpentAddressesSorterMultipleRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sorter-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sorter := NewSpentAddressesSorter(dir, 3)
+	for _, b := range []byte{9, 4, 4, 1, 8, 2, 9, 3, 5, 6, 1, 7} {
+		if err := sorter.Add(testAddr(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs, err := sorter.Runs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 4 {
+		t.Fatalf("expected 4 runs of 3 spent addresses, got %d", len(runs))
+	}
+
+	var addrs [][]byte
+	counts, err := MergeSpentAddresses(runs, collectSpentAddresses(&addrs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSpentAddresses(t, addrs, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+
+	// the duplicates within a run are dropped while writing it, the ones across runs while merging them
+	var read int
+	for _, c := range counts {
+		read += c.Read
+	}
+	if read != 11 {
+		t.Fatalf("expected 11 spent addresses within the runs, got %d", read)
+	}
+}
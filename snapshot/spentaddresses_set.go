@@ -0,0 +1,103 @@
+package snapshot
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// SpentAddressesSetOperation is a set operation over two sets of spent addresses.
+type SpentAddressesSetOperation string
+
+const (
+	// SpentAddressesUnion yields the spent addresses contained in any of the sets.
+	SpentAddressesUnion SpentAddressesSetOperation = "union"
+	// SpentAddressesDifference yields the spent addresses of the first set which are not in the second one.
+	SpentAddressesDifference SpentAddressesSetOperation = "difference"
+	// SpentAddressesIntersection yields the spent addresses contained in both sets.
+	SpentAddressesIntersection SpentAddressesSetOperation = "intersection"
+	// SpentAddressesSymmetricDifference yields the spent addresses contained in exactly one of the sets.
+	SpentAddressesSymmetricDifference SpentAddressesSetOperation = "symmetric-difference"
+)
+
+// ParseSpentAddressesSetOperation returns the set operation with the given name.
+func ParseSpentAddressesSetOperation(name string) (SpentAddressesSetOperation, error) {
+	switch op := SpentAddressesSetOperation(name); op {
+	case SpentAddressesUnion, SpentAddressesDifference, SpentAddressesIntersection, SpentAddressesSymmetricDifference:
+		return op, nil
+	}
+	return "", errors.Errorf("unknown set operation %s, expected %s, %s, %s or %s", name,
+		SpentAddressesUnion, SpentAddressesDifference, SpentAddressesIntersection, SpentAddressesSymmetricDifference)
+}
+
+// ApplySpentAddressesSetOperation applies the given set operation to the spent addresses passed by the stream functions a and b
+// and passes the resulting spent addresses in ascending byte order to the given function. Both streams must pass their
+// spent addresses in strictly ascending byte order, they are walked side by side using the merge of MergeSpentAddresses,
+// so only a bounded amount of spent addresses is held in memory. It returns the amount of spent addresses of a and b.
+func ApplySpentAddressesSetOperation(op SpentAddressesSetOperation, a, b func(fn func(addr []byte) error) error, fn func(addr []byte) error) ([2]int, error) {
+	var counts [2]int
+	var onlyA, onlyB, both bool
+	switch op {
+	case SpentAddressesUnion:
+		onlyA, onlyB, both = true, true, true
+	case SpentAddressesDifference:
+		onlyA = true
+	case SpentAddressesIntersection:
+		both = true
+	case SpentAddressesSymmetricDifference:
+		onlyA, onlyB = true, true
+	default:
+		return counts, errors.Errorf("unknown set operation %s", op)
+	}
+
+	// a spent address is passed once all streams containing it were visited, which is the case when the next one starts
+	pending := make([]byte, 0, HashBytesSize)
+	var inA, inB bool
+	emit := func() error {
+		if len(pending) == 0 || !(inA && inB && both || inA && !inB && onlyA || !inA && inB && onlyB) {
+			return nil
+		}
+		return fn(pending)
+	}
+	err := mergeSpentAddresses([]func(fn func(addr []byte) error) error{a, b}, func(addr []byte, index int, first bool) error {
+		counts[index]++
+		if first {
+			if err := emit(); err != nil {
+				return err
+			}
+			pending = append(pending[:0], addr...)
+			inA, inB = false, false
+		}
+		if index == 0 {
+			inA = true
+		} else {
+			inB = true
+		}
+		return nil
+	})
+	if err != nil {
+		return counts, err
+	}
+	return counts, emit()
+}
+
+// WriteSpentAddressesText writes the spent addresses streamed by the given function to the given writer
+// in the format of previousEpochsSpentAddresses.txt, one address in trytes per line.
+// It returns the amount of written spent addresses.
+func WriteSpentAddressesText(w io.Writer, stream func(fn func(addr []byte) error) error) (int, error) {
+	bw := bufio.NewWriter(w)
+	var count int
+	if err := stream(func(addr []byte) error {
+		trytes, err := bytesToHash(addr)
+		if err != nil {
+			return err
+		}
+		count++
+		_, err = bw.WriteString(trytes + "\n")
+		return err
+	}); err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
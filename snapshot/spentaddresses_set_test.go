@@ -0,0 +1,42 @@
+package snapshot
+
+import (
+	"testing"
+)
+
+func TestApplySpentAddressesSetOperation(t *testing.T) {
+	tests := []struct {
+		op       SpentAddressesSetOperation
+		expected []byte
+	}{
+		{SpentAddressesUnion, []byte{1, 2, 3, 4, 5, 6}},
+		{SpentAddressesDifference, []byte{1, 5}},
+		{SpentAddressesIntersection, []byte{2, 4}},
+		{SpentAddressesSymmetricDifference, []byte{1, 3, 5, 6}},
+	}
+	for _, test := range tests {
+		var addrs [][]byte
+		counts, err := ApplySpentAddressesSetOperation(test.op, testStream(1, 2, 4, 5), testStream(2, 3, 4, 6), collectSpentAddresses(&addrs))
+		if err != nil {
+			t.Fatalf("%s: %v", test.op, err)
+		}
+		expectSpentAddresses(t, addrs, test.expected...)
+		if counts != [2]int{4, 4} {
+			t.Fatalf("%s: expected both sets to contain 4 spent addresses, got %v", test.op, counts)
+		}
+	}
+}
+
+func TestApplySpentAddressesSetOperationEmptySet(t *testing.T) {
+	var addrs [][]byte
+	if _, err := ApplySpentAddressesSetOperation(SpentAddressesDifference, testStream(1, 2), testStream(), collectSpentAddresses(&addrs)); err != nil {
+		t.Fatal(err)
+	}
+	expectSpentAddresses(t, addrs, 1, 2)
+
+	addrs = nil
+	if _, err := ApplySpentAddressesSetOperation(SpentAddressesIntersection, testStream(), testStream(1, 2), collectSpentAddresses(&addrs)); err != nil {
+		t.Fatal(err)
+	}
+	expectSpentAddresses(t, addrs)
+}
@@ -0,0 +1,54 @@
+package snapshot
+
+import (
+	"github.com/iotaledger/iota.go/checksum"
+	"github.com/iotaledger/iota.go/guards"
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ChecksumTrytesSize is the amount of trytes of an address checksum.
+	ChecksumTrytesSize = 9
+	// HashWithChecksumTrytesSize is the amount of trytes of an address including its checksum.
+	HashWithChecksumTrytesSize = HashTrytesSize + ChecksumTrytesSize
+)
+
+// InvalidTrytesHandler is called for every line of a text source containing an invalid hash or address.
+// The line is skipped if it returns nil, otherwise reading is aborted with the returned error.
+type InvalidTrytesHandler func(err *ErrInvalidTrytes) error
+
+// TextOptions defines how a text source is read.
+type TextOptions struct {
+	// Source is the name of the source used to annotate errors, i.e. the file name.
+	Source string
+	// OnInvalidTrytes handles lines containing invalid hashes or addresses.
+	// If nil, reading fails with an ErrInvalidTrytes on the first invalid line.
+	OnInvalidTrytes InvalidTrytesHandler
+}
+
+// ParseHash validates the given hash or address, which must consist of 81 trytes.
+// Addresses of 90 trytes are accepted if their checksum is valid and are returned without it.
+func ParseHash(trytes string) (trinary.Hash, error) {
+	switch len(trytes) {
+	case HashTrytesSize:
+		if !guards.IsTrytes(trytes) {
+			return "", errors.Errorf("'%s' contains characters which are no trytes", trytes)
+		}
+		return trytes, nil
+	case HashWithChecksumTrytesSize:
+		if !guards.IsTrytes(trytes) {
+			return "", errors.Errorf("'%s' contains characters which are no trytes", trytes)
+		}
+		hash := trytes[:HashTrytesSize]
+		withChecksum, err := checksum.AddChecksum(hash, true, ChecksumTrytesSize)
+		if err != nil {
+			return "", err
+		}
+		if withChecksum != trytes {
+			return "", errors.Errorf("invalid checksum of '%s', expected %s", trytes, withChecksum[HashTrytesSize:])
+		}
+		return hash, nil
+	}
+	return "", errors.Errorf("invalid length %d of '%s', expected %d or %d trytes", len(trytes), trytes, HashTrytesSize, HashWithChecksumTrytesSize)
+}
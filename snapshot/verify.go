@@ -0,0 +1,191 @@
+package snapshot
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/iotaledger/iota.go/guards"
+	"github.com/iotaledger/iota.go/trinary"
+)
+
+// the checks performed by Verify.
+const (
+	CheckIndex       = "index"
+	CheckDuplicate   = "duplicate"
+	CheckZeroBalance = "zero-balance"
+	CheckTrytes      = "trytes"
+	CheckCount       = "count"
+	CheckSupply      = "supply"
+	CheckCheckpoint  = "checkpoint"
+)
+
+// the section names used in violations.
+const (
+	sectionMilestone        = "milestone"
+	sectionSolidEntryPoints = "solid entry points"
+	sectionSeenMilestones   = "seen milestones"
+	sectionLedgerEntries    = "ledger entries"
+)
+
+// Counts are the amounts of entries of a snapshot as declared by its source.
+// A negative count means the source does not declare the amount.
+type Counts struct {
+	SolidEntryPoints int32 `json:"solidEntryPoints"`
+	SeenMilestones   int32 `json:"seenMilestones"`
+	LedgerEntries    int32 `json:"ledgerEntries"`
+}
+
+// Violation is a single failed check of a snapshot verification.
+type Violation struct {
+	// Check is the failed check, one of the Check constants.
+	Check string `json:"check"`
+	// Section is the section of the snapshot the violation was found in.
+	Section string `json:"section,omitempty"`
+	// Hash is the hash or address of the violating entry.
+	Hash string `json:"hash,omitempty"`
+	// Message describes the violation.
+	Message string `json:"message"`
+}
+
+// VerifyOptions defines the expectations a snapshot is verified against.
+type VerifyOptions struct {
+	// Network defines the expected total supply of the ledger and whether it is checked.
+	Network Network
+	// Declared are the counts declared by the source of the snapshot, nil if the source declares none.
+	Declared *Counts
+	// Checkpoints are the known-good milestones the snapshot is checked against, see VerifyCheckpoints.
+	// No checkpoints are checked if nil.
+	Checkpoints Checkpoints
+}
+
+// Report is the result of a snapshot verification.
+type Report struct {
+	Source             string `json:"source"`
+	Network            string `json:"network"`
+	MilestoneHash      string `json:"milestoneHash"`
+	MilestoneIndex     int32  `json:"milestoneIndex"`
+	MilestoneTimestamp int64  `json:"milestoneTimestamp"`
+	Counts             Counts `json:"counts"`
+	Supply             uint64 `json:"supply"`
+	SupplyOverflow     bool   `json:"supplyOverflow"`
+	// CheckpointMatches is the amount of milestones and solid entry points which matched a checkpoint.
+	CheckpointMatches int         `json:"checkpointMatches"`
+	Valid             bool        `json:"valid"`
+	Violations        []Violation `json:"violations"`
+}
+
+// TotalSupply returns the sum of all balances of the ledger state
+// and whether the sum overflowed, in which case the returned sum is meaningless.
+func (s *Snapshot) TotalSupply() (uint64, bool) {
+	var total uint64
+	for _, balance := range s.LedgerState {
+		if balance > math.MaxUint64-total {
+			return total, true
+		}
+		total += balance
+	}
+	return total, false
+}
+
+// Verify checks the consistency of the given snapshot and returns a report of every violation.
+// Violations are reported in a deterministic order.
+func Verify(s *Snapshot, opts VerifyOptions) *Report {
+	report := &Report{
+		Network:            opts.Network.Name,
+		MilestoneHash:      s.MilestoneHash,
+		MilestoneIndex:     s.MilestoneIndex,
+		MilestoneTimestamp: s.MilestoneTimestamp,
+		Counts: Counts{
+			SolidEntryPoints: int32(len(s.SolidEntryPoints)),
+			SeenMilestones:   int32(len(s.SeenMilestones)),
+			LedgerEntries:    int32(len(s.LedgerState)),
+		},
+		Violations: []Violation{},
+	}
+	violation := func(check string, section string, hash string, format string, args ...interface{}) {
+		report.Violations = append(report.Violations, Violation{Check: check, Section: section, Hash: hash, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !isHash(s.MilestoneHash) {
+		violation(CheckTrytes, sectionMilestone, s.MilestoneHash, "milestone hash is not valid %d-tryte data", HashTrytesSize)
+	}
+	if s.MilestoneIndex <= 0 {
+		violation(CheckIndex, sectionMilestone, s.MilestoneHash, "milestone index %d is not positive", s.MilestoneIndex)
+	}
+
+	if opts.Declared != nil {
+		for _, c := range []struct {
+			section  string
+			declared int32
+			parsed   int32
+		}{
+			{sectionSolidEntryPoints, opts.Declared.SolidEntryPoints, report.Counts.SolidEntryPoints},
+			{sectionSeenMilestones, opts.Declared.SeenMilestones, report.Counts.SeenMilestones},
+			{sectionLedgerEntries, opts.Declared.LedgerEntries, report.Counts.LedgerEntries},
+		} {
+			if c.declared >= 0 && c.declared != c.parsed {
+				violation(CheckCount, c.section, "", "declared %d entries but parsed %d distinct entries", c.declared, c.parsed)
+			}
+		}
+	}
+
+	for _, hash := range sortedIndexHashes(s.SolidEntryPoints) {
+		index := s.SolidEntryPoints[hash]
+		if !isHash(hash) {
+			violation(CheckTrytes, sectionSolidEntryPoints, hash, "hash is not valid %d-tryte data", HashTrytesSize)
+		}
+		if index < 0 || index > s.MilestoneIndex {
+			violation(CheckIndex, sectionSolidEntryPoints, hash, "index %d is not within 0 and the milestone index %d", index, s.MilestoneIndex)
+		}
+		if _, has := s.SeenMilestones[hash]; has {
+			violation(CheckDuplicate, sectionSolidEntryPoints, hash, "hash is also a seen milestone")
+		}
+		if _, has := s.LedgerState[hash]; has {
+			violation(CheckDuplicate, sectionSolidEntryPoints, hash, "hash is also a ledger address")
+		}
+	}
+
+	for _, hash := range sortedIndexHashes(s.SeenMilestones) {
+		index := s.SeenMilestones[hash]
+		if !isHash(hash) {
+			violation(CheckTrytes, sectionSeenMilestones, hash, "hash is not valid %d-tryte data", HashTrytesSize)
+		}
+		if index <= s.MilestoneIndex {
+			violation(CheckIndex, sectionSeenMilestones, hash, "index %d is not above the milestone index %d", index, s.MilestoneIndex)
+		}
+		if _, has := s.LedgerState[hash]; has {
+			violation(CheckDuplicate, sectionSeenMilestones, hash, "hash is also a ledger address")
+		}
+	}
+
+	for _, addr := range sortedBalanceAddresses(s.LedgerState) {
+		if !isHash(addr) {
+			violation(CheckTrytes, sectionLedgerEntries, addr, "address is not valid %d-tryte data", HashTrytesSize)
+		}
+		if s.LedgerState[addr] == 0 {
+			violation(CheckZeroBalance, sectionLedgerEntries, addr, "balance is zero")
+		}
+	}
+
+	report.Supply, report.SupplyOverflow = s.TotalSupply()
+	switch {
+	case report.SupplyOverflow:
+		violation(CheckSupply, sectionLedgerEntries, "", "total supply overflows uint64")
+	case opts.Network.CheckSupply && report.Supply != opts.Network.Supply:
+		violation(CheckSupply, sectionLedgerEntries, "", "total supply %d does not match the expected supply %d of network %s",
+			report.Supply, opts.Network.Supply, opts.Network.Name)
+	}
+
+	if opts.Checkpoints != nil {
+		var violations []Violation
+		violations, report.CheckpointMatches = VerifyCheckpoints(s, opts.Checkpoints)
+		report.Violations = append(report.Violations, violations...)
+	}
+
+	report.Valid = len(report.Violations) == 0
+	return report
+}
+
+func isHash(hash trinary.Hash) bool {
+	return guards.IsTrytesOfExactLength(hash, HashTrytesSize)
+}
@@ -0,0 +1,148 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrCorrupted is returned when a file of the database can not be decoded.
+	ErrCorrupted = errors.New("corrupted database file")
+	// ErrUnsupported is returned when a database uses a RocksDB feature or format which is not implemented.
+	ErrUnsupported = errors.New("unsupported database format")
+	// ErrLocked is returned when a database is opened for writing while another store holds its LOCK file.
+	ErrLocked = errors.New("database is locked")
+)
+
+// the value types of internal keys.
+const (
+	typeDeletion       byte = 0x0
+	typeValue          byte = 0x1
+	typeMerge          byte = 0x2
+	typeSingleDeletion byte = 0x7
+	typeRangeDeletion  byte = 0xF
+	typeBlobIndex      byte = 0x11
+)
+
+// internalKeyTrailerSize is the size of the sequence number and value type appended to a user key.
+const internalKeyTrailerSize = 8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum returns the masked crc32c of the given data as stored by RocksDB.
+func checksum(data ...[]byte) uint32 {
+	var crc uint32
+	for _, d := range data {
+		crc = crc32.Update(crc, crcTable, d)
+	}
+	return ((crc >> 15) | (crc << 17)) + 0xa282ead8
+}
+
+// makeInternalKey appends the sequence number and value type to the given user key.
+func makeInternalKey(userKey []byte, seq uint64, valueType byte) []byte {
+	ikey := make([]byte, len(userKey)+internalKeyTrailerSize)
+	copy(ikey, userKey)
+	binary.LittleEndian.PutUint64(ikey[len(userKey):], seq<<8|uint64(valueType))
+	return ikey
+}
+
+// parseInternalKey splits an internal key into its user key, sequence number and value type.
+func parseInternalKey(ikey []byte) (userKey []byte, seq uint64, valueType byte, err error) {
+	if len(ikey) < internalKeyTrailerSize {
+		return nil, 0, 0, errors.Wrapf(ErrCorrupted, "internal key of %d bytes", len(ikey))
+	}
+	n := len(ikey) - internalKeyTrailerSize
+	trailer := binary.LittleEndian.Uint64(ikey[n:])
+	return ikey[:n], trailer >> 8, byte(trailer), nil
+}
+
+// userKey returns the user key of an internal key.
+func userKey(ikey []byte) []byte {
+	if len(ikey) < internalKeyTrailerSize {
+		return ikey
+	}
+	return ikey[:len(ikey)-internalKeyTrailerSize]
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(dst, buf[:n]...)
+}
+
+func appendFixed32(dst []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(dst, buf[:]...)
+}
+
+func appendLengthPrefixed(dst []byte, b []byte) []byte {
+	return append(appendUvarint(dst, uint64(len(b))), b...)
+}
+
+// decoder decodes the varint and length-prefixed encodings used by RocksDB.
+// It keeps the first error, after which all reads return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = errors.Wrapf(ErrCorrupted, format, args...)
+	}
+	d.b = nil
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail("invalid varint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) uvarint32() uint32 {
+	v := d.uvarint()
+	if v > 1<<32-1 {
+		d.fail("varint %d exceeds 32 bits", v)
+		return 0
+	}
+	return uint32(v)
+}
+
+func (d *decoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.b)) < n {
+		d.fail("%d bytes expected but only %d left", n, len(d.b))
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) lengthPrefixed() []byte {
+	return d.bytes(d.uvarint())
+}
+
+func (d *decoder) byte() byte {
+	b := d.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) empty() bool {
+	return len(d.b) == 0
+}
@@ -0,0 +1,86 @@
+package lsm
+
+import (
+	"bytes"
+)
+
+// iterator iterates entries in ascending order of their user keys and for each user key
+// from the newest to the oldest entry.
+type iterator interface {
+	next() bool
+	userKey() []byte
+	valueType() byte
+	value() []byte
+	error() error
+}
+
+type memIter struct {
+	keys    []string
+	entries []memEntry
+	i       int
+	key     []byte
+}
+
+func (it *memIter) next() bool {
+	it.i++
+	if it.i >= len(it.keys) {
+		return false
+	}
+	it.key = append(it.key[:0], it.keys[it.i]...)
+	return true
+}
+
+func (it *memIter) userKey() []byte {
+	return it.key
+}
+
+func (it *memIter) valueType() byte {
+	if it.entries[it.i].deleted {
+		return typeDeletion
+	}
+	return typeValue
+}
+
+func (it *memIter) value() []byte {
+	return it.entries[it.i].value
+}
+
+func (it *memIter) error() error {
+	return nil
+}
+
+// mergingItem is an iterator within a mergingIter, the rank orders iterators of the same key from the newest data.
+type mergingItem struct {
+	it   iterator
+	rank int
+}
+
+// mergingIter is a heap of iterators ordered by their current user key and rank.
+type mergingIter struct {
+	items []mergingItem
+}
+
+func (h *mergingIter) Len() int {
+	return len(h.items)
+}
+
+func (h *mergingIter) Less(i, j int) bool {
+	if c := bytes.Compare(h.items[i].it.userKey(), h.items[j].it.userKey()); c != 0 {
+		return c < 0
+	}
+	return h.items[i].rank < h.items[j].rank
+}
+
+func (h *mergingIter) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *mergingIter) Push(x interface{}) {
+	h.items = append(h.items, x.(mergingItem))
+}
+
+func (h *mergingIter) Pop() interface{} {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
@@ -0,0 +1,170 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// the log format is shared by the MANIFEST and the write-ahead logs. records are split into
+// fragments which do not cross the boundaries of fixed-size blocks.
+const (
+	logBlockSize            = 32768
+	logHeaderSize           = 7
+	logRecyclableHeaderSize = 11
+)
+
+// the fragment types of the log format.
+const (
+	logZeroType           = 0
+	logFullType           = 1
+	logFirstType          = 2
+	logMiddleType         = 3
+	logLastType           = 4
+	logRecyclableFullType = 5
+	logRecyclableLastType = 8
+)
+
+// logReader reads the records of a file in the log format.
+type logReader struct {
+	r     io.Reader
+	block [logBlockSize]byte
+	// buf is the unread part of the current block.
+	buf []byte
+	eof bool
+}
+
+func newLogReader(r io.Reader) *logReader {
+	return &logReader{r: r}
+}
+
+// next returns the next record or io.EOF if all records were read.
+// A record which ends prematurely yields io.ErrUnexpectedEOF.
+func (lr *logReader) next() ([]byte, error) {
+	var record []byte
+	fragmented := false
+	for {
+		if len(lr.buf) < logHeaderSize {
+			if lr.eof {
+				if fragmented {
+					return nil, io.ErrUnexpectedEOF
+				}
+				return nil, io.EOF
+			}
+			n, err := io.ReadFull(lr.r, lr.block[:])
+			switch err {
+			case nil:
+			case io.EOF, io.ErrUnexpectedEOF:
+				lr.eof = true
+			default:
+				return nil, err
+			}
+			lr.buf = lr.block[:n]
+			continue
+		}
+
+		length := int(binary.LittleEndian.Uint16(lr.buf[4:6]))
+		fragmentType := lr.buf[6]
+		headerSize := logHeaderSize
+		if fragmentType >= logRecyclableFullType && fragmentType <= logRecyclableLastType {
+			headerSize = logRecyclableHeaderSize
+			fragmentType -= logRecyclableFullType - logFullType
+		}
+		if fragmentType == logZeroType && length == 0 {
+			// the rest of the block is padding or preallocated space
+			lr.buf = nil
+			continue
+		}
+		if len(lr.buf) < headerSize+length {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if binary.LittleEndian.Uint32(lr.buf[:4]) != checksum(lr.buf[6:headerSize+length]) {
+			return nil, errors.Wrap(ErrCorrupted, "log record checksum mismatch")
+		}
+		fragment := lr.buf[headerSize : headerSize+length]
+		lr.buf = lr.buf[headerSize+length:]
+
+		switch fragmentType {
+		case logFullType:
+			if fragmented {
+				return nil, errors.Wrap(ErrCorrupted, "full log record within a fragmented record")
+			}
+			return append([]byte{}, fragment...), nil
+		case logFirstType:
+			if fragmented {
+				return nil, errors.Wrap(ErrCorrupted, "first log fragment within a fragmented record")
+			}
+			record = append(record[:0], fragment...)
+			fragmented = true
+		case logMiddleType, logLastType:
+			if !fragmented {
+				return nil, errors.Wrap(ErrCorrupted, "log fragment without a first fragment")
+			}
+			record = append(record, fragment...)
+			if fragmentType == logLastType {
+				return record, nil
+			}
+		default:
+			return nil, errors.Wrapf(ErrCorrupted, "unknown log fragment type %d", fragmentType)
+		}
+	}
+}
+
+// logWriter writes records in the log format.
+type logWriter struct {
+	w io.Writer
+	// blockOffset is the offset within the current block.
+	blockOffset int
+}
+
+func newLogWriter(w io.Writer) *logWriter {
+	return &logWriter{w: w}
+}
+
+func (lw *logWriter) write(record []byte) error {
+	first := true
+	for {
+		if left := logBlockSize - lw.blockOffset; left < logHeaderSize {
+			if _, err := lw.w.Write(make([]byte, left)); err != nil {
+				return err
+			}
+			lw.blockOffset = 0
+		}
+
+		fragment := record
+		if avail := logBlockSize - lw.blockOffset - logHeaderSize; len(fragment) > avail {
+			fragment = fragment[:avail]
+		}
+		last := len(fragment) == len(record)
+
+		var fragmentType byte
+		switch {
+		case first && last:
+			fragmentType = logFullType
+		case first:
+			fragmentType = logFirstType
+		case last:
+			fragmentType = logLastType
+		default:
+			fragmentType = logMiddleType
+		}
+
+		var header [logHeaderSize]byte
+		binary.LittleEndian.PutUint32(header[:4], checksum([]byte{fragmentType}, fragment))
+		binary.LittleEndian.PutUint16(header[4:6], uint16(len(fragment)))
+		header[6] = fragmentType
+		if _, err := lw.w.Write(header[:]); err != nil {
+			return err
+		}
+		if _, err := lw.w.Write(fragment); err != nil {
+			return err
+		}
+		lw.blockOffset += logHeaderSize + len(fragment)
+		record = record[len(fragment):]
+		first = false
+		if last {
+			return nil
+		}
+	}
+}
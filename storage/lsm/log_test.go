@@ -0,0 +1,74 @@
+package lsm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// testRecords returns records of the given sizes, filled with bytes depending on their index.
+func testRecords(sizes ...int) [][]byte {
+	records := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		records[i] = bytes.Repeat([]byte{byte(i + 1)}, size)
+	}
+	return records
+}
+
+// writeTestLog returns the given records written in the log format.
+func writeTestLog(t *testing.T, records [][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	lw := newLogWriter(&buf)
+	for _, record := range records {
+		if err := lw.write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestLogRoundTrip(t *testing.T) {
+	// a record leaving less than a header at the end of the first block, which is padded,
+	// a record filling the second block exactly and a record spanning several blocks
+	records := testRecords(logBlockSize-logHeaderSize-3, logBlockSize-logHeaderSize, 0, 10, 3*logBlockSize, 100)
+	log := writeTestLog(t, records)
+
+	lr := newLogReader(bytes.NewReader(log))
+	for i, expected := range records {
+		record, err := lr.next()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if !bytes.Equal(record, expected) {
+			t.Fatalf("expected record %d of %d bytes, got %d bytes", i, len(expected), len(record))
+		}
+	}
+	if _, err := lr.next(); err != io.EOF {
+		t.Fatalf("expected %v after the last record, got %v", io.EOF, err)
+	}
+}
+
+func TestLogTruncatedRecord(t *testing.T) {
+	log := writeTestLog(t, testRecords(10, 2*logBlockSize))
+
+	// the tail of a log whose writer terminated is missing
+	lr := newLogReader(bytes.NewReader(log[:logBlockSize+100]))
+	if _, err := lr.next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lr.next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestLogCorruptedRecord(t *testing.T) {
+	log := writeTestLog(t, testRecords(10))
+	log[logHeaderSize] ^= 0xFF
+
+	if _, err := newLogReader(bytes.NewReader(log)).next(); errors.Cause(err) != ErrCorrupted {
+		t.Fatalf("expected %v, got %v", ErrCorrupted, err)
+	}
+}
@@ -0,0 +1,811 @@
+// Package lsm implements a storage.Store in pure Go which reads and writes the on-disk format of RocksDB,
+// so that the databases of IRI can be accessed by a binary built without cgo.
+//
+// Only the subset of the format used by IRI's spent-addresses-db and localsnapshots-db is implemented:
+// block-based tables up to format version 3 with a binary or hash search index, uncompressed or compressed with
+// snappy, zlib or bzip2 blocks with CRC32C checksums, the MANIFEST and the write-ahead logs. Other compressions,
+// xxHash checksums, merge operands and range deletions are not supported and yield ErrUnsupported.
+//
+// Writes are appended to a write-ahead log unless it is disabled by the storage.WriteOptions of a batch,
+// kept in memory and written as level 0 table files when the memory limit is reached and when the store is flushed
+// or closed. Writes which bypassed the write-ahead log are lost if the process terminates before.
+package lsm
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/iotaledger/iri-ls-sa-merger/storage"
+	"github.com/pkg/errors"
+)
+
+const defaultColumnFamily = "default"
+
+// memtableSize is the amount of bytes of keys and values kept in memory before they are written as table files.
+const memtableSize = 64 * 1024 * 1024
+
+// Store is a storage.Store reading and writing a RocksDB database. It is safe for concurrent use.
+// A store opened for writing locks the LOCK file of the database, so that it can not be opened for writing
+// by another store at the same time. RocksDB locks the file differently, i.e. the database must not be used by IRI
+// at the same time.
+type Store struct {
+	dir      string
+	readOnly bool
+	// lock is the locked LOCK file of the database, nil if the store is read-only.
+	lock *os.File
+
+	mu             sync.RWMutex
+	version        *version
+	columnFamilies map[string]*columnFamily
+	// manifestNumber is the file number of the current MANIFEST.
+	manifestNumber uint64
+	// memSize is the amount of bytes of keys and values in the memtables.
+	memSize int
+	// dirty defines whether the memtables contain writes which were not persisted in table files.
+	dirty bool
+	// log is the write-ahead log of the memtables, nil if the store is read-only.
+	log *walWriter
+
+	tablesMu sync.Mutex
+	tables   map[uint64]*table
+}
+
+// Open opens the RocksDB database in the given folder with the given column families
+// and creates the database and missing column families if they do not exist. It implements storage.Opener.
+func Open(dir string, columnFamilies []string) (storage.Store, error) {
+	return open(dir, columnFamilies, false)
+}
+
+// OpenReadOnly opens the existing RocksDB database in the given folder with the given column families for reading.
+// No file of the database is written or removed, the writes of its write-ahead logs are only replayed into memory.
+// It implements storage.Opener.
+func OpenReadOnly(dir string, columnFamilies []string) (storage.Store, error) {
+	return open(dir, columnFamilies, true)
+}
+
+func open(dir string, columnFamilies []string, readOnly bool) (storage.Store, error) {
+	s := &Store{dir: dir, readOnly: readOnly, columnFamilies: make(map[string]*columnFamily), tables: make(map[uint64]*table)}
+	if !readOnly {
+		if err := s.lockDir(); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.open(columnFamilies); err != nil {
+		s.unlockDir()
+		return nil, err
+	}
+	return s, nil
+}
+
+// lockDir creates the LOCK file of the database and locks it exclusively,
+// so that no other store opens the database for writing until it is closed.
+func (s *Store) lockDir() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return errors.Wrapf(err, "unable to create database %s", s.dir)
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, "LOCK"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create LOCK file of database %s", s.dir)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return errors.Wrapf(ErrLocked, "database %s is already opened for writing by another process", s.dir)
+		}
+		return errors.Wrapf(err, "unable to lock database %s", s.dir)
+	}
+	s.lock = f
+	return nil
+}
+
+// unlockDir releases the LOCK file of the database, closing it releases the lock.
+func (s *Store) unlockDir() error {
+	if s.lock == nil {
+		return nil
+	}
+	err := s.lock.Close()
+	s.lock = nil
+	return err
+}
+
+// open reads or creates the database in the folder of the store and opens the given column families.
+func (s *Store) open(columnFamilies []string) error {
+	current, err := ioutil.ReadFile(filepath.Join(s.dir, "CURRENT"))
+	switch {
+	case err == nil:
+		if err := s.recover(strings.TrimSuffix(string(current), "\n")); err != nil {
+			return errors.Wrapf(err, "unable to recover database %s", s.dir)
+		}
+	case os.IsNotExist(err) && s.readOnly:
+		return errors.Wrapf(err, "database %s does not exist", s.dir)
+	case os.IsNotExist(err):
+		if err := s.create(); err != nil {
+			return errors.Wrapf(err, "unable to create database %s", s.dir)
+		}
+	default:
+		return err
+	}
+
+	if !s.readOnly {
+		// the writes replayed from the existing write-ahead logs stay in them until they are flushed
+		if err := s.newLog(); err != nil {
+			return errors.Wrapf(err, "unable to create write-ahead log of database %s", s.dir)
+		}
+	}
+	for _, name := range columnFamilies {
+		cf := s.version.columnFamilyByName(name)
+		if cf == nil && s.readOnly {
+			return errors.Wrapf(storage.ErrUnknownColumnFamily, "column family %s does not exist in database %s", name, s.dir)
+		}
+		if cf == nil {
+			s.version.maxColumnFamily++
+			cf = s.version.addColumnFamily(s.version.maxColumnFamily, name)
+			// the column family did not exist when the existing write-ahead logs were written
+			cf.logNumber = s.log.number
+		}
+		s.columnFamilies[name] = cf
+	}
+	// like RocksDB, a new MANIFEST is written whenever the database is opened for writing
+	if !s.readOnly {
+		if err := s.writeManifest(); err != nil {
+			s.log.close()
+			return errors.Wrapf(err, "unable to write MANIFEST of database %s", s.dir)
+		}
+	}
+	return nil
+}
+
+func (s *Store) create() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.dir, "IDENTITY"), []byte(hex.EncodeToString(id)), 0644); err != nil {
+		return err
+	}
+	s.version = newVersion()
+	return nil
+}
+
+// recover reads the state of the database from the given MANIFEST and replays the write-ahead logs.
+func (s *Store) recover(manifest string) error {
+	if !strings.HasPrefix(manifest, "MANIFEST-") {
+		return errors.Wrapf(ErrCorrupted, "CURRENT names %q", manifest)
+	}
+	number, err := strconv.ParseUint(strings.TrimPrefix(manifest, "MANIFEST-"), 10, 64)
+	if err != nil {
+		return errors.Wrapf(ErrCorrupted, "CURRENT names %q", manifest)
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, manifest))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s.version = newVersion()
+	lr := newLogReader(f)
+	for {
+		record, err := lr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, manifest)
+		}
+		edit, err := decodeVersionEdit(record)
+		if err != nil {
+			return errors.Wrap(err, manifest)
+		}
+		if err := s.version.apply(edit); err != nil {
+			return errors.Wrap(err, manifest)
+		}
+	}
+	s.manifestNumber = number
+
+	logs, err := s.listFiles(".log")
+	if err != nil {
+		return err
+	}
+	minLogNumber := s.version.minLogNumber()
+	for i, logNumber := range logs {
+		if logNumber >= s.version.nextFileNumber {
+			s.version.nextFileNumber = logNumber + 1
+		}
+		if logNumber < minLogNumber {
+			continue
+		}
+		// a torn write at the end of the newest log is tolerated, as RocksDB does
+		if err := s.replayLog(logNumber, i == len(logs)-1); err != nil {
+			return err
+		}
+	}
+
+	for _, cf := range s.version.columnFamilies {
+		if cf.mem.len() > 0 {
+			s.memSize += cf.mem.size
+			s.dirty = true
+		}
+	}
+	return nil
+}
+
+func (s *Store) replayLog(number uint64, tolerateTail bool) error {
+	name := fmt.Sprintf("%06d.log", number)
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	lr := newLogReader(f)
+	for {
+		record, err := lr.next()
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil && tolerateTail:
+			return nil
+		case err != nil:
+			return errors.Wrap(err, name)
+		}
+		if err := s.version.replayBatch(record, number); err != nil {
+			return errors.Wrap(err, name)
+		}
+	}
+}
+
+// listFiles returns the ascending numbers of the files with the given extension within the database folder.
+func (s *Store) listFiles(ext string) ([]uint64, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var numbers []uint64
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ext) {
+			continue
+		}
+		number, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers, nil
+}
+
+func (s *Store) newFileNumber() uint64 {
+	number := s.version.nextFileNumber
+	s.version.nextFileNumber++
+	return number
+}
+
+// writeManifest writes the current state into a new MANIFEST, points CURRENT to it
+// and removes the previous MANIFEST and the write-ahead logs which are not needed anymore.
+func (s *Store) writeManifest() error {
+	number := s.newFileNumber()
+	name := fmt.Sprintf("MANIFEST-%06d", number)
+	if err := s.writeSynced(name, func(w io.Writer) error {
+		lw := newLogWriter(w)
+		for _, edit := range s.version.snapshot() {
+			if err := lw.write(edit.encode()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	tmpName := fmt.Sprintf("%06d.dbtmp", number)
+	if err := s.writeSynced(tmpName, func(w io.Writer) error {
+		_, err := io.WriteString(w, name+"\n")
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(s.dir, tmpName), filepath.Join(s.dir, "CURRENT")); err != nil {
+		return err
+	}
+
+	if s.manifestNumber != 0 {
+		if err := os.Remove(filepath.Join(s.dir, fmt.Sprintf("MANIFEST-%06d", s.manifestNumber))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	s.manifestNumber = number
+
+	logs, err := s.listFiles(".log")
+	if err != nil {
+		return err
+	}
+	minLogNumber := s.version.minLogNumber()
+	for _, logNumber := range logs {
+		if logNumber >= minLogNumber {
+			break
+		}
+		if err := os.Remove(filepath.Join(s.dir, fmt.Sprintf("%06d.log", logNumber))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) writeSynced(name string, write func(w io.Writer) error) error {
+	f, err := os.Create(filepath.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := write(f); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// flush writes the memtables of all column families as level 0 table files.
+// s.mu must be held for writing.
+func (s *Store) flush() error {
+	ids := make([]uint32, 0, len(s.version.columnFamilies))
+	for id := range s.version.columnFamilies {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		cf := s.version.columnFamilies[id]
+		if cf.mem.len() == 0 {
+			continue
+		}
+		number := s.newFileNumber()
+		tw, err := newTableWriter(filepath.Join(s.dir, fmt.Sprintf("%06d.sst", number)), cf, number)
+		if err != nil {
+			return err
+		}
+		for _, key := range cf.mem.sortedKeys() {
+			entry := cf.mem.entries[key]
+			s.version.lastSequence++
+			valueType := typeValue
+			if entry.deleted {
+				valueType = typeDeletion
+			}
+			if err := tw.add(makeInternalKey([]byte(key), s.version.lastSequence, valueType), entry.value); err != nil {
+				tw.f.Close()
+				return err
+			}
+		}
+		f, err := tw.finish()
+		if err != nil {
+			return err
+		}
+		cf.files[f.number] = f
+		cf.mem = newMemtable()
+	}
+
+	// all writes are persisted in table files, existing write-ahead logs are not needed anymore
+	if err := s.newLog(); err != nil {
+		return err
+	}
+	for _, cf := range s.version.columnFamilies {
+		cf.logNumber = s.log.number
+	}
+	s.memSize = 0
+	s.dirty = false
+	return s.writeManifest()
+}
+
+// newLog replaces the current write-ahead log by a new one. s.mu must be held for writing.
+func (s *Store) newLog() error {
+	number := s.newFileNumber()
+	log, err := createWAL(filepath.Join(s.dir, fmt.Sprintf("%06d.log", number)), number)
+	if err != nil {
+		return err
+	}
+	if s.log != nil {
+		if err := s.log.close(); err != nil {
+			log.close()
+			return err
+		}
+	}
+	s.log = log
+	return nil
+}
+
+func (s *Store) columnFamily(cf string) (*columnFamily, error) {
+	c, has := s.columnFamilies[cf]
+	if !has {
+		return nil, errors.Wrapf(storage.ErrUnknownColumnFamily, "column family %s", cf)
+	}
+	return c, nil
+}
+
+// table returns the opened table file with the given number.
+func (s *Store) table(number uint64) (*table, error) {
+	s.tablesMu.Lock()
+	defer s.tablesMu.Unlock()
+	if t, has := s.tables[number]; has {
+		return t, nil
+	}
+	t, err := openTable(filepath.Join(s.dir, fmt.Sprintf("%06d.sst", number)))
+	if os.IsNotExist(errors.Cause(err)) {
+		// files of databases created by LevelDB compatible versions may use the old extension
+		t, err = openTable(filepath.Join(s.dir, fmt.Sprintf("%06d.ldb", number)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.tables[number] = t
+	return t, nil
+}
+
+// Get implements storage.Store.
+func (s *Store) Get(cf string, key []byte) ([]byte, error) {
+	s.mu.RLock()
+	c, err := s.columnFamily(cf)
+	if err != nil {
+		s.mu.RUnlock()
+		return nil, err
+	}
+	if entry, has := c.mem.entries[string(key)]; has {
+		s.mu.RUnlock()
+		if entry.deleted {
+			return nil, nil
+		}
+		return append([]byte{}, entry.value...), nil
+	}
+	files := c.sortedFiles()
+	s.mu.RUnlock()
+
+	for _, f := range files {
+		if bytes.Compare(key, userKey(f.smallest)) < 0 || bytes.Compare(key, userKey(f.largest)) > 0 {
+			continue
+		}
+		t, err := s.table(f.number)
+		if err != nil {
+			return nil, err
+		}
+		value, valueType, found, err := t.get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		switch valueType {
+		case typeValue:
+			return append([]byte{}, value...), nil
+		case typeDeletion, typeSingleDeletion:
+			return nil, nil
+		default:
+			return nil, unsupportedValueType(valueType)
+		}
+	}
+	return nil, nil
+}
+
+func unsupportedValueType(valueType byte) error {
+	switch valueType {
+	case typeMerge:
+		return errors.Wrap(ErrUnsupported, "merge operands")
+	case typeRangeDeletion:
+		return errors.Wrap(ErrUnsupported, "range deletions")
+	case typeBlobIndex:
+		return errors.Wrap(ErrUnsupported, "blob values")
+	}
+	return errors.Wrapf(ErrUnsupported, "value type %d", valueType)
+}
+
+// Put implements storage.Store. The write is appended to the write-ahead log.
+func (s *Store) Put(cf string, key []byte, value []byte) error {
+	return s.write([]write{{cf: cf, key: key, value: value}}, true)
+}
+
+// ForEach implements storage.Store. The entries are merged from the memtable and the table files
+// as they were when the iteration started.
+func (s *Store) ForEach(cf string, fn func(key []byte, value []byte) error) error {
+	s.mu.RLock()
+	c, err := s.columnFamily(cf)
+	if err != nil {
+		s.mu.RUnlock()
+		return err
+	}
+	iters := []iterator{c.mem.iter()}
+	files := c.sortedFiles()
+	s.mu.RUnlock()
+
+	for _, f := range files {
+		t, err := s.table(f.number)
+		if err != nil {
+			return err
+		}
+		iters = append(iters, &tableIter{t: t})
+	}
+
+	h := &mergingIter{}
+	for rank, it := range iters {
+		if it.next() {
+			h.items = append(h.items, mergingItem{it: it, rank: rank})
+		} else if err := it.error(); err != nil {
+			return err
+		}
+	}
+	heap.Init(h)
+
+	var key []byte
+	for h.Len() > 0 {
+		// the newest entry of a key is yielded by the iterator of the lowest rank
+		top := h.items[0].it
+		key = append(key[:0], top.userKey()...)
+		switch valueType := top.valueType(); valueType {
+		case typeValue:
+			if err := fn(key, top.value()); err != nil {
+				return err
+			}
+		case typeDeletion, typeSingleDeletion:
+		default:
+			return unsupportedValueType(valueType)
+		}
+
+		// skip all older entries of the key
+		for h.Len() > 0 && bytes.Equal(h.items[0].it.userKey(), key) {
+			it := h.items[0].it
+			valid := it.next()
+			for valid && bytes.Equal(it.userKey(), key) {
+				valid = it.next()
+			}
+			if valid {
+				heap.Fix(h, 0)
+				continue
+			}
+			if err := it.error(); err != nil {
+				return err
+			}
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// NewBatch implements storage.Store.
+func (s *Store) NewBatch(opts storage.WriteOptions) storage.Batch {
+	return &batch{store: s, disableWAL: opts.DisableWAL}
+}
+
+// write applies the given writes to the memtables entirely or not at all
+// and appends them to the write-ahead log beforehand if logged is set.
+func (s *Store) write(writes []write, logged bool) error {
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfs := make([]*columnFamily, len(writes))
+	for i, w := range writes {
+		cf, err := s.columnFamily(w.cf)
+		if err != nil {
+			return err
+		}
+		cfs[i] = cf
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+
+	seq := s.version.lastSequence + 1
+	if logged {
+		if err := s.log.write(encodeBatch(seq, writes, cfs)); err != nil {
+			return errors.Wrap(err, "unable to write to the write-ahead log")
+		}
+	}
+	s.version.lastSequence += uint64(len(writes))
+	for i, w := range writes {
+		s.memSize += cfs[i].mem.put(w.key, w.value)
+	}
+	s.dirty = true
+	if s.memSize >= memtableSize {
+		return s.flush()
+	}
+	return nil
+}
+
+// Flush implements storage.Store. Writes kept in memory are written as table files.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty || s.readOnly {
+		return nil
+	}
+	return s.flush()
+}
+
+// Close implements storage.Store. Writes kept in memory are written as table files.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.dirty && !s.readOnly {
+		err = s.flush()
+	}
+	s.tablesMu.Lock()
+	defer s.tablesMu.Unlock()
+	for number, t := range s.tables {
+		if closeErr := t.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		delete(s.tables, number)
+	}
+	if s.log != nil {
+		if closeErr := s.log.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		s.log = nil
+	}
+	if unlockErr := s.unlockDir(); unlockErr != nil && err == nil {
+		err = unlockErr
+	}
+	return err
+}
+
+type write struct {
+	cf    string
+	key   []byte
+	value []byte
+}
+
+type batch struct {
+	store      *Store
+	disableWAL bool
+	writes     []write
+}
+
+func (b *batch) Put(cf string, key []byte, value []byte) {
+	b.writes = append(b.writes, write{cf: cf, key: append([]byte{}, key...), value: append([]byte{}, value...)})
+}
+
+func (b *batch) Count() int {
+	return len(b.writes)
+}
+
+func (b *batch) Write() error {
+	if err := b.store.write(b.writes, !b.disableWAL); err != nil {
+		return err
+	}
+	b.writes = nil
+	return nil
+}
+
+func (b *batch) Close() {
+	b.writes = nil
+}
+
+// NewSortedWriter implements storage.BulkLoader. The pairs are written into table files which are added to the database
+// once Finish is called, into the bottommost level if they do not overlap existing data, otherwise into level 0.
+// The pairs take precedence over earlier writes, but not over writes made after the writer was created.
+func (s *Store) NewSortedWriter(cf string) (storage.SortedWriter, error) {
+	if s.readOnly {
+		return nil, storage.ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.columnFamily(cf)
+	if err != nil {
+		return nil, err
+	}
+	// writes kept in memory are assigned their sequence numbers when flushed, which must be lower than the pairs'
+	if s.dirty {
+		if err := s.flush(); err != nil {
+			return nil, err
+		}
+	}
+	s.version.lastSequence++
+	return &sortedWriter{store: s, cf: c, seq: s.version.lastSequence}, nil
+}
+
+type sortedWriter struct {
+	store *Store
+	cf    *columnFamily
+	// seq is the sequence number of all pairs.
+	seq uint64
+
+	// tw writes the current table file, nil if none is open.
+	tw      *tableWriter
+	size    int
+	files   []*fileMeta
+	lastKey []byte
+}
+
+func (w *sortedWriter) Put(key []byte, value []byte) error {
+	if w.lastKey != nil && bytes.Compare(w.lastKey, key) >= 0 {
+		return errors.Wrapf(storage.ErrNotSorted, "key %x after %x", key, w.lastKey)
+	}
+	if w.tw == nil {
+		w.store.mu.Lock()
+		number := w.store.newFileNumber()
+		w.store.mu.Unlock()
+		tw, err := newTableWriter(filepath.Join(w.store.dir, fmt.Sprintf("%06d.sst", number)), w.cf, number)
+		if err != nil {
+			return err
+		}
+		w.tw = tw
+	}
+	if err := w.tw.add(makeInternalKey(key, w.seq, typeValue), value); err != nil {
+		return err
+	}
+	w.lastKey = append(w.lastKey[:0], key...)
+	w.size += len(key) + len(value)
+	if w.size >= memtableSize {
+		return w.finishTable()
+	}
+	return nil
+}
+
+func (w *sortedWriter) finishTable() error {
+	if w.tw == nil {
+		return nil
+	}
+	f, err := w.tw.finish()
+	w.tw = nil
+	w.size = 0
+	if err != nil {
+		return err
+	}
+	w.files = append(w.files, f)
+	return nil
+}
+
+func (w *sortedWriter) Finish() error {
+	if err := w.finishTable(); err != nil {
+		return err
+	}
+	if len(w.files) == 0 {
+		return nil
+	}
+
+	s := w.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	level := numLevels - 1
+	smallest, largest := userKey(w.files[0].smallest), userKey(w.files[len(w.files)-1].largest)
+	for _, f := range w.cf.files {
+		if bytes.Compare(userKey(f.smallest), largest) <= 0 && bytes.Compare(userKey(f.largest), smallest) >= 0 {
+			level = 0
+			break
+		}
+	}
+	for _, f := range w.files {
+		f.level = level
+		w.cf.files[f.number] = f
+	}
+	w.files = nil
+	return s.writeManifest()
+}
+
+func (w *sortedWriter) Close() {
+	if w.tw != nil {
+		w.tw.f.Close()
+		os.Remove(w.tw.f.Name())
+		w.tw = nil
+	}
+	// files which were not added to the database are removed
+	for _, f := range w.files {
+		os.Remove(filepath.Join(w.store.dir, fmt.Sprintf("%06d.sst", f.number)))
+	}
+	w.files = nil
+}
@@ -0,0 +1,311 @@
+package lsm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/iotaledger/iri-ls-sa-merger/storage"
+	"github.com/pkg/errors"
+)
+
+// openTestStore opens the database in the given folder with the column families of a localsnapshots-db.
+func openTestStore(t *testing.T, dir string, readOnly bool) *Store {
+	t.Helper()
+	s, err := open(dir, storage.LocalSnapshotsDBColumnFamilies, readOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*Store)
+}
+
+// expectTestPairs fails the test if the given column family does not consist of the given keys and values,
+// which must be passed in ascending order of their keys.
+func expectTestPairs(t *testing.T, s storage.Store, cf string, pairs ...string) {
+	t.Helper()
+	var persisted []string
+	if err := s.ForEach(cf, func(key []byte, value []byte) error {
+		persisted = append(persisted, string(key), string(value))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(persisted, pairs) {
+		t.Fatalf("expected the pairs %v in column family %s, got %v", pairs, cf, persisted)
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		value, err := s.Get(cf, []byte(pairs[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(value) != pairs[i+1] {
+			t.Fatalf("expected the value %q of key %s in column family %s, got %q", pairs[i+1], pairs[i], cf, value)
+		}
+	}
+}
+
+// writeTestStore writes pairs using every kind of write into the database in the given folder and returns the store.
+func writeTestStore(t *testing.T, dir string) *Store {
+	t.Helper()
+	s := openTestStore(t, dir, false)
+	if err := s.Put(storage.ColumnFamilyLocalSnapshots, []byte("ls"), []byte("put")); err != nil {
+		t.Fatal(err)
+	}
+	b := s.NewBatch(storage.WriteOptions{})
+	b.Put(storage.ColumnFamilySpentAddresses, []byte("b"), nil)
+	b.Put(storage.ColumnFamilyLocalSnapshots, []byte("ls"), []byte("logged"))
+	if err := b.Write(); err != nil {
+		t.Fatal(err)
+	}
+	b = s.NewBatch(storage.WriteOptions{DisableWAL: true})
+	b.Put(storage.ColumnFamilySpentAddresses, []byte("d"), nil)
+	if err := b.Write(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// crashTestStore simulates that the process terminates without closing the given store,
+// which releases the lock of the database.
+func crashTestStore(t *testing.T, s *Store) {
+	t.Helper()
+	if err := s.log.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.unlockDir(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lsm-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := writeTestStore(t, dir)
+	w, err := s.NewSortedWriter(storage.ColumnFamilySpentAddresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "c", "e"} {
+		if err := w.Put([]byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Put([]byte("a"), nil); errors.Cause(err) != storage.ErrNotSorted {
+		t.Fatalf("expected %v, got %v", storage.ErrNotSorted, err)
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	expectTestPairs(t, s, storage.ColumnFamilySpentAddresses, "a", "", "b", "", "c", "", "d", "", "e", "")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestStore(t, dir, false)
+	defer s.Close()
+	expectTestPairs(t, s, storage.ColumnFamilySpentAddresses, "a", "", "b", "", "c", "", "d", "", "e", "")
+	expectTestPairs(t, s, storage.ColumnFamilyLocalSnapshots, "ls", "logged")
+	expectTestPairs(t, s, storage.ColumnFamilyDefault)
+}
+
+func TestStoreWriteAheadLogRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lsm-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the process terminates without closing the store, so only the write-ahead log persisted the writes
+	s := writeTestStore(t, dir)
+	crashTestStore(t, s)
+
+	s = openTestStore(t, dir, false)
+	expectTestPairs(t, s, storage.ColumnFamilySpentAddresses, "b", "")
+	expectTestPairs(t, s, storage.ColumnFamilyLocalSnapshots, "ls", "logged")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the recovered writes were flushed when the store was closed
+	s = openTestStore(t, dir, false)
+	defer s.Close()
+	expectTestPairs(t, s, storage.ColumnFamilySpentAddresses, "b", "")
+}
+
+// testDirState returns the names and contents of the files in the given folder.
+func testDirState(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := make(map[string][]byte)
+	for _, entry := range entries {
+		content, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		state[entry.Name()] = content
+	}
+	return state
+}
+
+func TestStoreReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lsm-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the write-ahead log contains writes which were not flushed
+	s := writeTestStore(t, dir)
+	crashTestStore(t, s)
+	before := testDirState(t, dir)
+
+	s = openTestStore(t, dir, true)
+	expectTestPairs(t, s, storage.ColumnFamilyLocalSnapshots, "ls", "logged")
+	if err := s.Put(storage.ColumnFamilyLocalSnapshots, []byte("ls"), nil); err != storage.ErrReadOnly {
+		t.Fatalf("expected %v, got %v", storage.ErrReadOnly, err)
+	}
+	b := s.NewBatch(storage.WriteOptions{DisableWAL: true})
+	b.Put(storage.ColumnFamilyLocalSnapshots, []byte("ls"), nil)
+	if err := b.Write(); err != storage.ErrReadOnly {
+		t.Fatalf("expected %v, got %v", storage.ErrReadOnly, err)
+	}
+	if _, err := s.NewSortedWriter(storage.ColumnFamilySpentAddresses); err != storage.ErrReadOnly {
+		t.Fatalf("expected %v, got %v", storage.ErrReadOnly, err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if after := testDirState(t, dir); !reflect.DeepEqual(after, before) {
+		t.Fatalf("expected the files of %s to remain unchanged", dir)
+	}
+
+	if _, err := OpenReadOnly(dir, []string{"unknown"}); errors.Cause(err) != storage.ErrUnknownColumnFamily {
+		t.Fatalf("expected %v, got %v", storage.ErrUnknownColumnFamily, err)
+	}
+	missing := filepath.Join(dir, "missing")
+	if _, err := OpenReadOnly(missing, storage.LocalSnapshotsDBColumnFamilies); err == nil {
+		t.Fatalf("expected opening the missing database %s to fail", missing)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Fatalf("expected the missing database %s not to be created, got %v", missing, err)
+	}
+}
+
+// TestStoreRocksDBFixture reads a localsnapshots-db written by RocksDB 5.18.3 through the cgo store of this repository
+// with its default options, i.e. snappy compressed tables. The first session wrote the spent addresses with even numbers
+// and "flushed" as the value of the local snapshot bypassing the write-ahead log and flushed them into table files.
+// The second session wrote every hundredth spent address starting at 1 and "logged" as the value of the local snapshot,
+// which RocksDB only persisted in its write-ahead log. The info logs and the LOCK file were not included.
+func TestStoreRocksDBFixture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lsm-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the fixture is copied, so that a failing test does not change it
+	fixture := filepath.Join("testdata", "rocksdb-5.18.3")
+	for name, content := range testDirState(t, fixture) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := testDirState(t, dir)
+
+	s := openTestStore(t, dir, true)
+	var expected []string
+	for i := 0; i < 1000; i++ {
+		if i%2 == 0 || i%100 == 1 {
+			expected = append(expected, fmt.Sprintf("spent-address-%04d", i), "")
+		}
+	}
+	expectTestPairs(t, s, storage.ColumnFamilySpentAddresses, expected...)
+	expectTestPairs(t, s, storage.ColumnFamilyLocalSnapshots, "ls", "logged")
+	expectTestPairs(t, s, storage.ColumnFamilyDefault)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(testDirState(t, dir), before) {
+		t.Fatalf("expected the files of %s to remain unchanged", dir)
+	}
+
+	// the database is recovered and written like one created by the pure Go store
+	s = openTestStore(t, dir, false)
+	if err := s.Put(storage.ColumnFamilySpentAddresses, []byte("spent-address-1000"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = openTestStore(t, dir, true)
+	defer s.Close()
+	expectTestPairs(t, s, storage.ColumnFamilySpentAddresses, append(expected, "spent-address-1000", "")...)
+	expectTestPairs(t, s, storage.ColumnFamilyLocalSnapshots, "ls", "logged")
+}
+
+func TestStoreLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lsm-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := openTestStore(t, dir, false)
+	if _, err := Open(dir, storage.LocalSnapshotsDBColumnFamilies); errors.Cause(err) != ErrLocked {
+		t.Fatalf("expected %v, got %v", ErrLocked, err)
+	}
+	// reading does not require the lock
+	r := openTestStore(t, dir, true)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestStore(t, dir, false)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreGetMissingKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lsm-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := writeTestStore(t, dir)
+	defer s.Close()
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "c", "z"} {
+		value, err := s.Get(storage.ColumnFamilySpentAddresses, []byte(key))
+		if err != nil || value != nil {
+			t.Fatalf("expected no value of the missing key %s, got %q, %v", key, value, err)
+		}
+	}
+	if _, err := s.Get("unknown", []byte("a")); errors.Cause(err) != storage.ErrUnknownColumnFamily {
+		t.Fatalf("expected %v, got %v", storage.ErrUnknownColumnFamily, err)
+	}
+	if value, err := s.Get(storage.ColumnFamilySpentAddresses, []byte("b")); err != nil || !bytes.Equal(value, []byte{}) {
+		t.Fatalf("expected the empty value of key b, got %q, %v", value, err)
+	}
+}
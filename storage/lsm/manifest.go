@@ -0,0 +1,387 @@
+package lsm
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// bytewiseComparator is the name of the only comparator supported, RocksDB's default one.
+const bytewiseComparator = "leveldb.BytewiseComparator"
+
+// numLevels is the amount of levels of RocksDB's default level compaction.
+const numLevels = 7
+
+// the tags of the fields of a version edit.
+const (
+	tagComparator         = 1
+	tagLogNumber          = 2
+	tagNextFileNumber     = 3
+	tagLastSequence       = 4
+	tagCompactPointer     = 5
+	tagDeletedFile        = 6
+	tagNewFile            = 7
+	tagPrevLogNumber      = 9
+	tagMinLogNumberToKeep = 10
+	tagDBID               = 11
+	tagNewFile2           = 100
+	tagNewFile3           = 102
+	tagNewFile4           = 103
+	tagColumnFamily       = 200
+	tagColumnFamilyAdd    = 201
+	tagColumnFamilyDrop   = 202
+	tagMaxColumnFamily    = 203
+	tagInAtomicGroup      = 300
+	// tags with this bit set may be ignored by readers which do not know them.
+	tagSafeIgnoreMask = 1 << 13
+)
+
+// the tags of the custom fields of a kNewFile4 entry.
+const (
+	newFileTagTerminate = 1
+	newFileTagPathID    = 65
+	// custom fields with this bit set must not be ignored by readers which do not know them.
+	newFileTagNonSafeIgnoreMask = 1 << 6
+)
+
+// fileMeta describes a table file of a column family.
+type fileMeta struct {
+	level       int
+	number      uint64
+	size        uint64
+	smallest    []byte
+	largest     []byte
+	smallestSeq uint64
+	largestSeq  uint64
+}
+
+type deletedFile struct {
+	level  int
+	number uint64
+}
+
+// versionEdit is a record of the MANIFEST, describing changes to the files of the database.
+type versionEdit struct {
+	comparator         string
+	hasComparator      bool
+	logNumber          uint64
+	hasLogNumber       bool
+	prevLogNumber      uint64
+	hasPrevLogNumber   bool
+	nextFileNumber     uint64
+	hasNextFileNumber  bool
+	lastSequence       uint64
+	hasLastSequence    bool
+	maxColumnFamily    uint32
+	hasMaxColumnFamily bool
+
+	columnFamily       uint32
+	columnFamilyAdd    string
+	isColumnFamilyAdd  bool
+	isColumnFamilyDrop bool
+
+	newFiles     []*fileMeta
+	deletedFiles []deletedFile
+}
+
+func decodeVersionEdit(record []byte) (*versionEdit, error) {
+	edit := &versionEdit{}
+	d := &decoder{b: record}
+	for !d.empty() && d.err == nil {
+		tag := d.uvarint32()
+		switch tag {
+		case tagComparator:
+			edit.comparator = string(d.lengthPrefixed())
+			edit.hasComparator = true
+		case tagLogNumber:
+			edit.logNumber = d.uvarint()
+			edit.hasLogNumber = true
+		case tagPrevLogNumber:
+			edit.prevLogNumber = d.uvarint()
+			edit.hasPrevLogNumber = true
+		case tagNextFileNumber:
+			edit.nextFileNumber = d.uvarint()
+			edit.hasNextFileNumber = true
+		case tagLastSequence:
+			edit.lastSequence = d.uvarint()
+			edit.hasLastSequence = true
+		case tagMaxColumnFamily:
+			edit.maxColumnFamily = d.uvarint32()
+			edit.hasMaxColumnFamily = true
+		case tagMinLogNumberToKeep:
+			d.uvarint()
+		case tagDBID:
+			d.lengthPrefixed()
+		case tagInAtomicGroup:
+			d.uvarint32()
+		case tagCompactPointer:
+			d.uvarint32()
+			d.lengthPrefixed()
+		case tagDeletedFile:
+			level := int(d.uvarint32())
+			edit.deletedFiles = append(edit.deletedFiles, deletedFile{level: level, number: d.uvarint()})
+		case tagNewFile, tagNewFile2, tagNewFile3, tagNewFile4:
+			f, err := decodeNewFile(d, tag)
+			if err != nil {
+				return nil, err
+			}
+			edit.newFiles = append(edit.newFiles, f)
+		case tagColumnFamily:
+			edit.columnFamily = d.uvarint32()
+		case tagColumnFamilyAdd:
+			edit.columnFamilyAdd = string(d.lengthPrefixed())
+			edit.isColumnFamilyAdd = true
+		case tagColumnFamilyDrop:
+			edit.isColumnFamilyDrop = true
+		default:
+			if tag&tagSafeIgnoreMask == 0 {
+				return nil, errors.Wrapf(ErrUnsupported, "unknown MANIFEST tag %d", tag)
+			}
+			d.lengthPrefixed()
+		}
+	}
+	if d.err != nil {
+		return nil, errors.Wrap(d.err, "MANIFEST record")
+	}
+	return edit, nil
+}
+
+func decodeNewFile(d *decoder, tag uint32) (*fileMeta, error) {
+	f := &fileMeta{level: int(d.uvarint32()), number: d.uvarint()}
+	if tag == tagNewFile3 {
+		if pathID := d.uvarint32(); pathID != 0 {
+			return nil, errors.Wrapf(ErrUnsupported, "table file %d is stored in db path %d", f.number, pathID)
+		}
+	}
+	f.size = d.uvarint()
+	f.smallest = append([]byte{}, d.lengthPrefixed()...)
+	f.largest = append([]byte{}, d.lengthPrefixed()...)
+	if tag != tagNewFile {
+		f.smallestSeq = d.uvarint()
+		f.largestSeq = d.uvarint()
+	}
+	if tag == tagNewFile4 {
+		for d.err == nil {
+			fieldTag := d.uvarint32()
+			if fieldTag == newFileTagTerminate {
+				break
+			}
+			field := d.lengthPrefixed()
+			switch {
+			case fieldTag == newFileTagPathID:
+				if len(field) != 1 || field[0] != 0 {
+					return nil, errors.Wrapf(ErrUnsupported, "table file %d is not stored in the first db path", f.number)
+				}
+			case fieldTag&newFileTagNonSafeIgnoreMask != 0:
+				return nil, errors.Wrapf(ErrUnsupported, "unknown field %d of table file %d", fieldTag, f.number)
+			}
+		}
+	}
+	// the file number may be packed together with the path id
+	f.number &= 1<<62 - 1
+	if f.level < 0 || f.level >= numLevels {
+		return nil, errors.Wrapf(ErrUnsupported, "table file %d at level %d", f.number, f.level)
+	}
+	return f, d.err
+}
+
+func (edit *versionEdit) encode() []byte {
+	var b []byte
+	if edit.hasComparator {
+		b = appendUvarint(b, tagComparator)
+		b = appendLengthPrefixed(b, []byte(edit.comparator))
+	}
+	if edit.hasLogNumber {
+		b = appendUvarint(b, tagLogNumber)
+		b = appendUvarint(b, edit.logNumber)
+	}
+	if edit.hasPrevLogNumber {
+		b = appendUvarint(b, tagPrevLogNumber)
+		b = appendUvarint(b, edit.prevLogNumber)
+	}
+	if edit.hasNextFileNumber {
+		b = appendUvarint(b, tagNextFileNumber)
+		b = appendUvarint(b, edit.nextFileNumber)
+	}
+	if edit.hasLastSequence {
+		b = appendUvarint(b, tagLastSequence)
+		b = appendUvarint(b, edit.lastSequence)
+	}
+	if edit.hasMaxColumnFamily {
+		b = appendUvarint(b, tagMaxColumnFamily)
+		b = appendUvarint(b, uint64(edit.maxColumnFamily))
+	}
+	for _, f := range edit.newFiles {
+		b = appendUvarint(b, tagNewFile4)
+		b = appendUvarint(b, uint64(f.level))
+		b = appendUvarint(b, f.number)
+		b = appendUvarint(b, f.size)
+		b = appendLengthPrefixed(b, f.smallest)
+		b = appendLengthPrefixed(b, f.largest)
+		b = appendUvarint(b, f.smallestSeq)
+		b = appendUvarint(b, f.largestSeq)
+		b = appendUvarint(b, newFileTagTerminate)
+	}
+	if edit.columnFamily != 0 {
+		b = appendUvarint(b, tagColumnFamily)
+		b = appendUvarint(b, uint64(edit.columnFamily))
+	}
+	if edit.isColumnFamilyAdd {
+		b = appendUvarint(b, tagColumnFamilyAdd)
+		b = appendLengthPrefixed(b, []byte(edit.columnFamilyAdd))
+	}
+	return b
+}
+
+// columnFamily is the state of a column family as recorded by the MANIFEST.
+type columnFamily struct {
+	id        uint32
+	name      string
+	logNumber uint64
+	files     map[uint64]*fileMeta
+	mem       *memtable
+}
+
+// version is the state of the database as recorded by the MANIFEST.
+type version struct {
+	columnFamilies  map[uint32]*columnFamily
+	nextFileNumber  uint64
+	lastSequence    uint64
+	maxColumnFamily uint32
+}
+
+func newVersion() *version {
+	v := &version{columnFamilies: make(map[uint32]*columnFamily), nextFileNumber: 2}
+	v.addColumnFamily(0, defaultColumnFamily)
+	return v
+}
+
+func (v *version) addColumnFamily(id uint32, name string) *columnFamily {
+	cf := &columnFamily{id: id, name: name, files: make(map[uint64]*fileMeta), mem: newMemtable()}
+	v.columnFamilies[id] = cf
+	if id > v.maxColumnFamily {
+		v.maxColumnFamily = id
+	}
+	return cf
+}
+
+func (v *version) columnFamilyByName(name string) *columnFamily {
+	for _, cf := range v.columnFamilies {
+		if cf.name == name {
+			return cf
+		}
+	}
+	return nil
+}
+
+func (v *version) apply(edit *versionEdit) error {
+	if edit.hasComparator && edit.comparator != bytewiseComparator {
+		return errors.Wrapf(ErrUnsupported, "comparator %s", edit.comparator)
+	}
+
+	switch {
+	case edit.isColumnFamilyAdd:
+		if _, has := v.columnFamilies[edit.columnFamily]; has {
+			return errors.Wrapf(ErrCorrupted, "column family %d added twice", edit.columnFamily)
+		}
+		v.addColumnFamily(edit.columnFamily, edit.columnFamilyAdd)
+	case edit.isColumnFamilyDrop:
+		delete(v.columnFamilies, edit.columnFamily)
+	}
+
+	cf, has := v.columnFamilies[edit.columnFamily]
+	if !has {
+		if edit.isColumnFamilyDrop || (len(edit.newFiles) == 0 && len(edit.deletedFiles) == 0) {
+			// edits of dropped column families are ignored
+			return v.applyGlobal(edit)
+		}
+		return errors.Wrapf(ErrCorrupted, "edit of unknown column family %d", edit.columnFamily)
+	}
+	for _, f := range edit.deletedFiles {
+		delete(cf.files, f.number)
+	}
+	for _, f := range edit.newFiles {
+		cf.files[f.number] = f
+	}
+	if edit.hasLogNumber {
+		cf.logNumber = edit.logNumber
+	}
+	return v.applyGlobal(edit)
+}
+
+func (v *version) applyGlobal(edit *versionEdit) error {
+	if edit.hasNextFileNumber {
+		v.nextFileNumber = edit.nextFileNumber
+	}
+	if edit.hasLastSequence {
+		v.lastSequence = edit.lastSequence
+	}
+	if edit.hasMaxColumnFamily && edit.maxColumnFamily > v.maxColumnFamily {
+		v.maxColumnFamily = edit.maxColumnFamily
+	}
+	return nil
+}
+
+// snapshot returns the edits which recreate the version in a new MANIFEST.
+func (v *version) snapshot() []*versionEdit {
+	ids := make([]uint32, 0, len(v.columnFamilies))
+	for id := range v.columnFamilies {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var edits []*versionEdit
+	for _, id := range ids {
+		cf := v.columnFamilies[id]
+		if id != 0 {
+			edits = append(edits, &versionEdit{columnFamily: id, columnFamilyAdd: cf.name, isColumnFamilyAdd: true,
+				comparator: bytewiseComparator, hasComparator: true})
+		} else {
+			edits = append(edits, &versionEdit{comparator: bytewiseComparator, hasComparator: true})
+		}
+		edits = append(edits, &versionEdit{columnFamily: id, logNumber: cf.logNumber, hasLogNumber: true, newFiles: cf.sortedFiles()})
+	}
+	edits = append(edits, &versionEdit{
+		nextFileNumber: v.nextFileNumber, hasNextFileNumber: true,
+		lastSequence: v.lastSequence, hasLastSequence: true,
+		hasPrevLogNumber: true,
+		maxColumnFamily:  v.maxColumnFamily, hasMaxColumnFamily: true,
+	})
+	return edits
+}
+
+// minLogNumber returns the number of the oldest write-ahead log which contains writes not yet in table files.
+func (v *version) minLogNumber() uint64 {
+	var min uint64
+	first := true
+	for _, cf := range v.columnFamilies {
+		if first || cf.logNumber < min {
+			min = cf.logNumber
+			first = false
+		}
+	}
+	return min
+}
+
+// sortedFiles returns the table files of the column family from the newest to the oldest data,
+// as they have to be searched: level 0 by descending sequence numbers followed by the levels 1 and above.
+func (cf *columnFamily) sortedFiles() []*fileMeta {
+	files := make([]*fileMeta, 0, len(cf.files))
+	for _, f := range cf.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.level != b.level {
+			return a.level < b.level
+		}
+		if a.level == 0 {
+			if a.largestSeq != b.largestSeq {
+				return a.largestSeq > b.largestSeq
+			}
+			return a.number > b.number
+		}
+		return string(a.smallest) < string(b.smallest)
+	})
+	return files
+}
@@ -0,0 +1,82 @@
+package lsm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestVersionEditRoundTrip(t *testing.T) {
+	edits := []*versionEdit{
+		{comparator: bytewiseComparator, hasComparator: true},
+		{columnFamily: 2, columnFamilyAdd: "spent-addresses", isColumnFamilyAdd: true, comparator: bytewiseComparator, hasComparator: true},
+		{columnFamily: 2, logNumber: 9, hasLogNumber: true, newFiles: []*fileMeta{
+			{level: 0, number: 12, size: 4096, smallest: makeInternalKey([]byte("a"), 5, typeValue),
+				largest: makeInternalKey([]byte("z"), 7, typeValue), smallestSeq: 5, largestSeq: 7},
+			{level: numLevels - 1, number: 13, size: 1 << 40, smallest: makeInternalKey([]byte("0"), 1, typeValue),
+				largest: makeInternalKey([]byte("9"), 1, typeValue), smallestSeq: 1, largestSeq: 1},
+		}},
+		{nextFileNumber: 14, hasNextFileNumber: true, lastSequence: 7, hasLastSequence: true, hasPrevLogNumber: true,
+			maxColumnFamily: 2, hasMaxColumnFamily: true},
+	}
+	for i, edit := range edits {
+		decoded, err := decodeVersionEdit(edit.encode())
+		if err != nil {
+			t.Fatalf("edit %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(decoded, edit) {
+			t.Fatalf("expected edit %d to decode to %+v, got %+v", i, edit, decoded)
+		}
+	}
+}
+
+func TestVersionSnapshotRoundTrip(t *testing.T) {
+	v := newVersion()
+	cf := v.addColumnFamily(3, "localsnapshots")
+	cf.logNumber = 8
+	cf.files[10] = &fileMeta{level: 1, number: 10, size: 100, smallest: makeInternalKey([]byte("a"), 2, typeValue),
+		largest: makeInternalKey([]byte("b"), 3, typeValue), smallestSeq: 2, largestSeq: 3}
+	v.nextFileNumber = 11
+	v.lastSequence = 3
+
+	// a new MANIFEST consists of the encoded snapshot of the version, which is recovered like the MANIFEST of a database
+	restored := newVersion()
+	for _, edit := range v.snapshot() {
+		decoded, err := decodeVersionEdit(edit.encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := restored.apply(decoded); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if restored.nextFileNumber != v.nextFileNumber || restored.lastSequence != v.lastSequence || restored.maxColumnFamily != v.maxColumnFamily {
+		t.Fatalf("expected the version %+v, got %+v", v, restored)
+	}
+	if len(restored.columnFamilies) != 2 {
+		t.Fatalf("expected 2 column families, got %d", len(restored.columnFamilies))
+	}
+	restoredCF := restored.columnFamilyByName("localsnapshots")
+	if restoredCF == nil || restoredCF.id != 3 || restoredCF.logNumber != 8 || !reflect.DeepEqual(restoredCF.files, cf.files) {
+		t.Fatalf("expected the column family %+v, got %+v", cf, restoredCF)
+	}
+}
+
+func TestVersionEditUnknownTag(t *testing.T) {
+	// tags which may be ignored are followed by a length prefixed value
+	record := appendLengthPrefixed(appendUvarint(nil, tagSafeIgnoreMask|1), []byte("ignored"))
+	record = append(record, (&versionEdit{lastSequence: 3, hasLastSequence: true}).encode()...)
+	edit, err := decodeVersionEdit(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit.lastSequence != 3 {
+		t.Fatalf("expected the last sequence 3 after an ignored tag, got %d", edit.lastSequence)
+	}
+
+	if _, err := decodeVersionEdit(bytes.Join([][]byte{appendUvarint(nil, 99), record}, nil)); errors.Cause(err) != ErrUnsupported {
+		t.Fatalf("expected %v, got %v", ErrUnsupported, err)
+	}
+}
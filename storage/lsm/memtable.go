@@ -0,0 +1,64 @@
+package lsm
+
+import (
+	"sort"
+)
+
+type memEntry struct {
+	value   []byte
+	deleted bool
+}
+
+// memtable keeps the writes of a column family which were not yet written into a table file.
+type memtable struct {
+	entries map[string]memEntry
+	// size is the amount of bytes of keys and values.
+	size int
+}
+
+func newMemtable() *memtable {
+	return &memtable{entries: make(map[string]memEntry)}
+}
+
+// put sets the value of the given key and returns the amount of bytes the memtable grew.
+func (m *memtable) put(key []byte, value []byte) int {
+	grown := len(key) + len(value)
+	if prev, has := m.entries[string(key)]; has {
+		grown -= len(key) + len(prev.value)
+	}
+	m.entries[string(key)] = memEntry{value: append([]byte{}, value...)}
+	m.size += grown
+	return grown
+}
+
+func (m *memtable) delete(key []byte) {
+	if prev, has := m.entries[string(key)]; has {
+		m.size -= len(prev.value)
+	} else {
+		m.size += len(key)
+	}
+	m.entries[string(key)] = memEntry{deleted: true}
+}
+
+func (m *memtable) len() int {
+	return len(m.entries)
+}
+
+func (m *memtable) sortedKeys() []string {
+	keys := make([]string, 0, len(m.entries))
+	for key := range m.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// iter returns an iterator over the current entries of the memtable.
+func (m *memtable) iter() *memIter {
+	keys := m.sortedKeys()
+	entries := make([]memEntry, len(keys))
+	for i, key := range keys {
+		entries[i] = m.entries[key]
+	}
+	return &memIter{keys: keys, entries: entries, i: -1}
+}
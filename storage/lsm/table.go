@@ -0,0 +1,636 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+)
+
+// the magic numbers at the end of block-based table files.
+const (
+	blockBasedTableMagic       uint64 = 0x88e241b785f4cff7
+	legacyBlockBasedTableMagic uint64 = 0xdb4775248b80fb57
+)
+
+const (
+	legacyFooterSize = 48
+	footerSize       = 53
+	// blockHandlesSize is the space the two block handles of a footer are padded to.
+	blockHandlesSize = 40
+	// blockTrailerSize is the size of the compression type and checksum following each block.
+	blockTrailerSize = 5
+)
+
+// the checksum types of block-based tables.
+const (
+	checksumNone     = 0
+	checksumCRC32C   = 1
+	checksumXXHash   = 2
+	checksumXXHash64 = 3
+)
+
+// the compression types of blocks.
+const (
+	compressionNone   = 0
+	compressionSnappy = 1
+	compressionZlib   = 2
+	compressionBZip2  = 3
+)
+
+// the names of the meta blocks and properties which are evaluated.
+const (
+	metaBlockProperties     = "rocksdb.properties"
+	metaBlockRangeDeletions = "rocksdb.range_del"
+	propIndexType           = "rocksdb.block.based.table.index.type"
+	propIndexKeyIsUserKey   = "rocksdb.index.key.is.user.key"
+	propIndexValueIsDelta   = "rocksdb.index.value.is.delta.encoded"
+)
+
+// the index types of block-based tables, only a binary search index can be read.
+const (
+	indexTypeBinarySearch = 0
+	indexTypeHashSearch   = 1
+)
+
+// tableFormatVersion is the format version of the written table files, the default of RocksDB 5.x.
+const tableFormatVersion = 2
+
+const (
+	tableBlockSize            = 4096
+	tableBlockRestartInterval = 16
+)
+
+type blockHandle struct {
+	offset uint64
+	size   uint64
+}
+
+func decodeBlockHandle(d *decoder) blockHandle {
+	return blockHandle{offset: d.uvarint(), size: d.uvarint()}
+}
+
+func (h blockHandle) append(dst []byte) []byte {
+	return appendUvarint(appendUvarint(dst, h.offset), h.size)
+}
+
+type indexEntry struct {
+	// lastKey is a key greater than or equal to the last key of the block.
+	lastKey []byte
+	handle  blockHandle
+}
+
+// table is a block-based table file opened for reading.
+type table struct {
+	f             *os.File
+	name          string
+	checksumType  byte
+	formatVersion uint32
+	index         []indexEntry
+	// indexKeyIsUserKey defines whether the keys of the index are user keys instead of internal keys.
+	indexKeyIsUserKey bool
+}
+
+func openTable(path string) (*table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{f: f, name: path}
+	if err := t.init(); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "table file %s", path)
+	}
+	return t, nil
+}
+
+func (t *table) init() error {
+	info, err := t.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < legacyFooterSize {
+		return errors.Wrap(ErrCorrupted, "file too short")
+	}
+
+	footer := make([]byte, footerSize)
+	if info.Size() < footerSize {
+		footer = footer[:legacyFooterSize]
+	}
+	if _, err := t.f.ReadAt(footer, info.Size()-int64(len(footer))); err != nil {
+		return err
+	}
+
+	var handles []byte
+	switch binary.LittleEndian.Uint64(footer[len(footer)-8:]) {
+	case blockBasedTableMagic:
+		if len(footer) != footerSize {
+			return errors.Wrap(ErrCorrupted, "file too short")
+		}
+		t.checksumType = footer[0]
+		handles = footer[1 : 1+blockHandlesSize]
+		t.formatVersion = binary.LittleEndian.Uint32(footer[footerSize-12:])
+	case legacyBlockBasedTableMagic:
+		t.checksumType = checksumCRC32C
+		handles = footer[len(footer)-legacyFooterSize : len(footer)-8]
+	default:
+		return errors.Wrap(ErrUnsupported, "not a block-based table")
+	}
+	if t.formatVersion > 3 {
+		return errors.Wrapf(ErrUnsupported, "table format version %d", t.formatVersion)
+	}
+	switch t.checksumType {
+	case checksumNone, checksumCRC32C:
+	case checksumXXHash, checksumXXHash64:
+		// blocks are never read without verifying their checksum
+		return errors.Wrap(ErrUnsupported, "xxHash block checksums")
+	default:
+		return errors.Wrapf(ErrUnsupported, "checksum type %d", t.checksumType)
+	}
+
+	d := &decoder{b: handles}
+	metaIndexHandle := decodeBlockHandle(d)
+	indexHandle := decodeBlockHandle(d)
+	if d.err != nil {
+		return errors.Wrap(d.err, "footer")
+	}
+
+	metaIndex, err := t.readBlockEntries(metaIndexHandle)
+	if err != nil {
+		return errors.Wrap(err, "meta index block")
+	}
+	if h, has := metaIndex[metaBlockRangeDeletions]; has {
+		rangeDeletions, err := t.readBlockEntries(h)
+		if err != nil {
+			return errors.Wrap(err, "range deletions block")
+		}
+		if len(rangeDeletions) > 0 {
+			return errors.Wrap(ErrUnsupported, "range deletions")
+		}
+	}
+	if h, has := metaIndex[metaBlockProperties]; has {
+		if err := t.readProperties(h); err != nil {
+			return errors.Wrap(err, "properties block")
+		}
+	}
+
+	block, err := t.readBlock(indexHandle)
+	if err != nil {
+		return errors.Wrap(err, "index block")
+	}
+	it, err := newBlockIter(block)
+	if err != nil {
+		return errors.Wrap(err, "index block")
+	}
+	for it.next() {
+		d := &decoder{b: it.value}
+		entry := indexEntry{lastKey: append([]byte{}, it.key...), handle: decodeBlockHandle(d)}
+		if d.err != nil {
+			return errors.Wrap(d.err, "index block")
+		}
+		if !t.indexKeyIsUserKey {
+			entry.lastKey = userKey(entry.lastKey)
+		}
+		t.index = append(t.index, entry)
+	}
+	return errors.Wrap(it.err, "index block")
+}
+
+// readBlockEntries reads a meta block whose values are block handles, i.e. the meta index block.
+func (t *table) readBlockEntries(h blockHandle) (map[string]blockHandle, error) {
+	block, err := t.readBlock(h)
+	if err != nil {
+		return nil, err
+	}
+	it, err := newBlockIter(block)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]blockHandle)
+	for it.next() {
+		d := &decoder{b: it.value}
+		entries[string(it.key)] = decodeBlockHandle(d)
+	}
+	return entries, it.err
+}
+
+func (t *table) readProperties(h blockHandle) error {
+	block, err := t.readBlock(h)
+	if err != nil {
+		return err
+	}
+	it, err := newBlockIter(block)
+	if err != nil {
+		return err
+	}
+	for it.next() {
+		switch string(it.key) {
+		case propIndexType:
+			if len(it.value) != 4 {
+				return errors.Wrap(ErrCorrupted, "index type property")
+			}
+			switch indexType := binary.LittleEndian.Uint32(it.value); indexType {
+			case indexTypeBinarySearch, indexTypeHashSearch:
+			default:
+				return errors.Wrapf(ErrUnsupported, "index type %d", indexType)
+			}
+		case propIndexKeyIsUserKey:
+			d := &decoder{b: it.value}
+			t.indexKeyIsUserKey = d.uvarint() != 0
+		case propIndexValueIsDelta:
+			if d := (&decoder{b: it.value}); d.uvarint() != 0 {
+				return errors.Wrap(ErrUnsupported, "delta encoded index")
+			}
+		}
+	}
+	return it.err
+}
+
+// readBlock reads, verifies and decompresses the block with the given handle.
+func (t *table) readBlock(h blockHandle) ([]byte, error) {
+	buf := make([]byte, h.size+blockTrailerSize)
+	if _, err := t.f.ReadAt(buf, int64(h.offset)); err != nil {
+		return nil, errors.Wrapf(err, "block at offset %d", h.offset)
+	}
+	data, compression := buf[:h.size], buf[h.size]
+	// the checksum type was checked when the table was opened
+	if t.checksumType == checksumCRC32C && binary.LittleEndian.Uint32(buf[h.size+1:]) != checksum(buf[:h.size+1]) {
+		return nil, errors.Wrapf(ErrCorrupted, "checksum mismatch of block at offset %d", h.offset)
+	}
+
+	switch compression {
+	case compressionNone:
+		return data, nil
+	case compressionSnappy:
+		block, err := snappy.Decode(nil, data)
+		if err != nil {
+			return nil, errors.Wrapf(ErrCorrupted, "snappy block at offset %d: %v", h.offset, err)
+		}
+		return block, nil
+	case compressionZlib, compressionBZip2:
+		if t.formatVersion >= 2 {
+			// the decompressed size is prepended
+			d := &decoder{b: data}
+			d.uvarint32()
+			if d.err != nil {
+				return nil, errors.Wrapf(d.err, "block at offset %d", h.offset)
+			}
+			data = d.b
+		}
+		var block []byte
+		var err error
+		if compression == compressionZlib {
+			// RocksDB writes raw deflate streams
+			block, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+		} else {
+			block, err = ioutil.ReadAll(bzip2.NewReader(bytes.NewReader(data)))
+		}
+		if err != nil {
+			return nil, errors.Wrapf(ErrCorrupted, "compressed block at offset %d: %v", h.offset, err)
+		}
+		return block, nil
+	}
+	return nil, errors.Wrapf(ErrUnsupported, "compression type %d of block at offset %d", compression, h.offset)
+}
+
+// get returns the newest entry of the given user key, found is false if the table does not contain the key.
+func (t *table) get(key []byte) (value []byte, valueType byte, found bool, err error) {
+	i := sort.Search(len(t.index), func(i int) bool {
+		return bytes.Compare(t.index[i].lastKey, key) >= 0
+	})
+	it := &tableIter{t: t, i: i}
+	for it.next() {
+		switch c := bytes.Compare(it.key, key); {
+		case c == 0:
+			return it.entryValue, it.entryType, true, nil
+		case c > 0:
+			return nil, 0, false, nil
+		}
+	}
+	return nil, 0, false, it.err
+}
+
+func (t *table) close() error {
+	return t.f.Close()
+}
+
+// tableIter iterates the entries of a table in the order of their internal keys,
+// i.e. ascending user keys and for each user key from the newest to the oldest entry.
+type tableIter struct {
+	t *table
+	// i is the index entry of the next block.
+	i     int
+	block *blockIter
+
+	key        []byte
+	entryType  byte
+	entryValue []byte
+	err        error
+}
+
+func (it *tableIter) next() bool {
+	if it.err != nil {
+		return false
+	}
+	for it.err == nil {
+		if it.block != nil {
+			if it.block.next() {
+				it.key, _, it.entryType, it.err = parseInternalKey(it.block.key)
+				it.entryValue = it.block.value
+				return it.err == nil
+			}
+			if it.err = it.block.err; it.err != nil {
+				break
+			}
+		}
+		if it.i >= len(it.t.index) {
+			return false
+		}
+		block, err := it.t.readBlock(it.t.index[it.i].handle)
+		if err != nil {
+			it.err = err
+			break
+		}
+		it.i++
+		it.block, it.err = newBlockIter(block)
+	}
+	it.err = errors.Wrapf(it.err, "table file %s", it.t.name)
+	return false
+}
+
+func (it *tableIter) userKey() []byte {
+	return it.key
+}
+
+func (it *tableIter) valueType() byte {
+	return it.entryType
+}
+
+func (it *tableIter) value() []byte {
+	return it.entryValue
+}
+
+func (it *tableIter) error() error {
+	return it.err
+}
+
+// blockIter iterates the entries of a block.
+type blockIter struct {
+	// data are the entries of the block without the restart points.
+	data  []byte
+	key   []byte
+	value []byte
+	err   error
+}
+
+func newBlockIter(block []byte) (*blockIter, error) {
+	if len(block) < 4 {
+		return nil, errors.Wrap(ErrCorrupted, "block too short")
+	}
+	numRestarts := binary.LittleEndian.Uint32(block[len(block)-4:])
+	if numRestarts&(1<<31) != 0 {
+		return nil, errors.Wrap(ErrUnsupported, "data block hash index")
+	}
+	restartsOffset := len(block) - 4 - 4*int(numRestarts)
+	if restartsOffset < 0 {
+		return nil, errors.Wrapf(ErrCorrupted, "block with %d restart points", numRestarts)
+	}
+	return &blockIter{data: block[:restartsOffset]}, nil
+}
+
+func (it *blockIter) next() bool {
+	if len(it.data) == 0 || it.err != nil {
+		return false
+	}
+	d := &decoder{b: it.data}
+	shared := d.uvarint()
+	nonShared := d.uvarint()
+	valueLen := d.uvarint()
+	if d.err == nil && shared > uint64(len(it.key)) {
+		d.fail("block entry shares %d bytes of a key of %d bytes", shared, len(it.key))
+	}
+	it.key = append(it.key[:shared], d.bytes(nonShared)...)
+	it.value = d.bytes(valueLen)
+	if d.err != nil {
+		it.err = d.err
+		return false
+	}
+	it.data = d.b
+	return true
+}
+
+// blockBuilder builds a block of prefix compressed entries.
+type blockBuilder struct {
+	restartInterval int
+	buf             []byte
+	restarts        []uint32
+	// counter is the amount of entries since the last restart point.
+	counter int
+	lastKey []byte
+	entries int
+}
+
+func newBlockBuilder(restartInterval int) *blockBuilder {
+	return &blockBuilder{restartInterval: restartInterval, restarts: []uint32{0}}
+}
+
+func (b *blockBuilder) add(key []byte, value []byte) {
+	shared := 0
+	if b.counter < b.restartInterval {
+		for shared < len(key) && shared < len(b.lastKey) && key[shared] == b.lastKey[shared] {
+			shared++
+		}
+	} else {
+		b.restarts = append(b.restarts, uint32(len(b.buf)))
+		b.counter = 0
+	}
+	b.buf = appendUvarint(b.buf, uint64(shared))
+	b.buf = appendUvarint(b.buf, uint64(len(key)-shared))
+	b.buf = appendUvarint(b.buf, uint64(len(value)))
+	b.buf = append(b.buf, key[shared:]...)
+	b.buf = append(b.buf, value...)
+	b.lastKey = append(b.lastKey[:0], key...)
+	b.counter++
+	b.entries++
+}
+
+// size returns the size of the finished block.
+func (b *blockBuilder) size() int {
+	return len(b.buf) + 4*len(b.restarts) + 4
+}
+
+func (b *blockBuilder) finish() []byte {
+	for _, restart := range b.restarts {
+		b.buf = appendFixed32(b.buf, restart)
+	}
+	return appendFixed32(b.buf, uint32(len(b.restarts)))
+}
+
+func (b *blockBuilder) reset() {
+	b.buf = b.buf[:0]
+	b.restarts = append(b.restarts[:0], 0)
+	b.counter = 0
+	b.lastKey = b.lastKey[:0]
+	b.entries = 0
+}
+
+// tableWriter writes a block-based table file with uncompressed blocks.
+// The entries must be added in ascending order of their internal keys.
+type tableWriter struct {
+	f      *os.File
+	w      *bufio.Writer
+	offset uint64
+
+	columnFamily *columnFamily
+	data         *blockBuilder
+	index        *blockBuilder
+	meta         *fileMeta
+
+	numEntries   uint64
+	numBlocks    uint64
+	rawKeySize   uint64
+	rawValueSize uint64
+}
+
+func newTableWriter(path string, cf *columnFamily, number uint64) (*tableWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{
+		f:            f,
+		w:            bufio.NewWriterSize(f, 1<<20),
+		columnFamily: cf,
+		data:         newBlockBuilder(tableBlockRestartInterval),
+		index:        newBlockBuilder(1),
+		meta:         &fileMeta{number: number},
+	}, nil
+}
+
+func (tw *tableWriter) add(ikey []byte, value []byte) error {
+	_, seq, _, err := parseInternalKey(ikey)
+	if err != nil {
+		return err
+	}
+	if tw.numEntries == 0 {
+		tw.meta.smallest = append([]byte{}, ikey...)
+		tw.meta.smallestSeq = seq
+	}
+	tw.meta.largest = append(tw.meta.largest[:0], ikey...)
+	if seq < tw.meta.smallestSeq {
+		tw.meta.smallestSeq = seq
+	}
+	if seq > tw.meta.largestSeq {
+		tw.meta.largestSeq = seq
+	}
+	tw.numEntries++
+	tw.rawKeySize += uint64(len(ikey))
+	tw.rawValueSize += uint64(len(value))
+
+	tw.data.add(ikey, value)
+	if tw.data.size() >= tableBlockSize {
+		return tw.flushDataBlock()
+	}
+	return nil
+}
+
+func (tw *tableWriter) flushDataBlock() error {
+	if tw.data.entries == 0 {
+		return nil
+	}
+	lastKey := append([]byte{}, tw.data.lastKey...)
+	h, err := tw.writeBlock(tw.data.finish())
+	if err != nil {
+		return err
+	}
+	tw.index.add(lastKey, h.append(nil))
+	tw.data.reset()
+	tw.numBlocks++
+	return nil
+}
+
+func (tw *tableWriter) writeBlock(block []byte) (blockHandle, error) {
+	h := blockHandle{offset: tw.offset, size: uint64(len(block))}
+	trailer := make([]byte, blockTrailerSize)
+	trailer[0] = compressionNone
+	binary.LittleEndian.PutUint32(trailer[1:], checksum(block, trailer[:1]))
+	if _, err := tw.w.Write(block); err != nil {
+		return h, err
+	}
+	if _, err := tw.w.Write(trailer); err != nil {
+		return h, err
+	}
+	tw.offset += uint64(len(block)) + blockTrailerSize
+	return h, nil
+}
+
+// finish writes the index, the properties and the footer, syncs the file and returns its metadata.
+func (tw *tableWriter) finish() (*fileMeta, error) {
+	defer tw.f.Close()
+	if err := tw.flushDataBlock(); err != nil {
+		return nil, err
+	}
+	dataSize := tw.offset
+
+	indexBlock := tw.index.finish()
+	props := newBlockBuilder(1)
+	for _, prop := range []struct {
+		name  string
+		value []byte
+	}{
+		{propIndexType, appendFixed32(nil, indexTypeBinarySearch)},
+		{"rocksdb.column.family.id", appendUvarint(nil, uint64(tw.columnFamily.id))},
+		{"rocksdb.column.family.name", []byte(tw.columnFamily.name)},
+		{"rocksdb.comparator", []byte(bytewiseComparator)},
+		{"rocksdb.compression", []byte("NoCompression")},
+		{"rocksdb.data.size", appendUvarint(nil, dataSize)},
+		{"rocksdb.filter.size", appendUvarint(nil, 0)},
+		{"rocksdb.fixed.key.length", appendUvarint(nil, 0)},
+		{"rocksdb.index.size", appendUvarint(nil, uint64(len(indexBlock)+blockTrailerSize))},
+		{"rocksdb.num.data.blocks", appendUvarint(nil, tw.numBlocks)},
+		{"rocksdb.num.entries", appendUvarint(nil, tw.numEntries)},
+		{"rocksdb.raw.key.size", appendUvarint(nil, tw.rawKeySize)},
+		{"rocksdb.raw.value.size", appendUvarint(nil, tw.rawValueSize)},
+	} {
+		props.add([]byte(prop.name), prop.value)
+	}
+	propsHandle, err := tw.writeBlock(props.finish())
+	if err != nil {
+		return nil, err
+	}
+
+	metaIndex := newBlockBuilder(1)
+	metaIndex.add([]byte(metaBlockProperties), propsHandle.append(nil))
+	metaIndexHandle, err := tw.writeBlock(metaIndex.finish())
+	if err != nil {
+		return nil, err
+	}
+	indexHandle, err := tw.writeBlock(indexBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	footer := make([]byte, footerSize)
+	footer[0] = checksumCRC32C
+	copy(footer[1:], indexHandle.append(metaIndexHandle.append(nil)))
+	binary.LittleEndian.PutUint32(footer[footerSize-12:], tableFormatVersion)
+	binary.LittleEndian.PutUint64(footer[footerSize-8:], blockBasedTableMagic)
+	if _, err := tw.w.Write(footer); err != nil {
+		return nil, err
+	}
+	if err := tw.w.Flush(); err != nil {
+		return nil, err
+	}
+	if err := tw.f.Sync(); err != nil {
+		return nil, err
+	}
+	tw.meta.size = tw.offset + footerSize
+	return tw.meta, nil
+}
@@ -0,0 +1,122 @@
+package lsm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// writeTestTable writes a table file with the given amount of keys into dir and returns its path.
+// The values of the keys span several blocks, the first key has an older entry as well.
+func writeTestTable(t *testing.T, dir string, keys int) string {
+	t.Helper()
+	path := filepath.Join(dir, "000001.sst")
+	tw, err := newTableWriter(path, &columnFamily{id: 1, name: "test"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < keys; i++ {
+		if err := tw.add(makeInternalKey(testTableKey(i), 2, typeValue), testTableValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			if err := tw.add(makeInternalKey(testTableKey(i), 1, typeValue), []byte("older")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	meta, err := tw.finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || uint64(info.Size()) != meta.size {
+		t.Fatalf("expected a table file of %d bytes, got %v, %v", meta.size, info, err)
+	}
+	return path
+}
+
+func testTableKey(i int) []byte {
+	return []byte(fmt.Sprintf("key-%05d", i))
+}
+
+func testTableValue(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, i%100)
+}
+
+func TestTableRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "table-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const keys = 2000
+	tbl, err := openTable(writeTestTable(t, dir, keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tbl.close()
+	if len(tbl.index) < 2 {
+		t.Fatalf("expected several data blocks, got %d", len(tbl.index))
+	}
+
+	it := &tableIter{t: tbl}
+	for i := 0; i < keys; i++ {
+		if !it.next() {
+			t.Fatalf("expected key %d, got %v", i, it.error())
+		}
+		if !bytes.Equal(it.userKey(), testTableKey(i)) || !bytes.Equal(it.value(), testTableValue(i)) || it.valueType() != typeValue {
+			t.Fatalf("expected key %s, got %s", testTableKey(i), it.userKey())
+		}
+		if i == 0 && (!it.next() || !bytes.Equal(it.value(), []byte("older"))) {
+			t.Fatalf("expected the older entry of key %s, got %v", testTableKey(i), it.error())
+		}
+	}
+	if it.next() || it.error() != nil {
+		t.Fatalf("expected %d keys, got another one or %v", keys, it.error())
+	}
+
+	for _, i := range []int{0, 1, keys / 2, keys - 1} {
+		value, valueType, found, err := tbl.get(testTableKey(i))
+		if err != nil || !found || valueType != typeValue || !bytes.Equal(value, testTableValue(i)) {
+			t.Fatalf("expected to get key %s, got %v, %v", testTableKey(i), found, err)
+		}
+	}
+	for _, key := range []string{"a", "key-00000-", "key-99999"} {
+		if _, _, found, err := tbl.get([]byte(key)); err != nil || found {
+			t.Fatalf("expected not to find key %s, got %v, %v", key, found, err)
+		}
+	}
+}
+
+func TestTableXXHashChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "table-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeTestTable(t, dir, 10)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the checksum type is the first byte of the footer
+	if _, err := f.WriteAt([]byte{checksumXXHash}, info.Size()-footerSize); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if _, err := openTable(path); errors.Cause(err) != ErrUnsupported {
+		t.Fatalf("expected %v, got %v", ErrUnsupported, err)
+	}
+}
@@ -0,0 +1,128 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// the record types of a write batch.
+const (
+	batchDeletion                   = 0x0
+	batchValue                      = 0x1
+	batchMerge                      = 0x2
+	batchLogData                    = 0x3
+	batchColumnFamilyDeletion       = 0x4
+	batchColumnFamilyValue          = 0x5
+	batchColumnFamilyMerge          = 0x6
+	batchSingleDeletion             = 0x7
+	batchColumnFamilySingleDeletion = 0x8
+	batchBeginPrepareXID            = 0x9
+	batchEndPrepareXID              = 0xA
+	batchCommitXID                  = 0xB
+	batchRollbackXID                = 0xC
+	batchNoop                       = 0xD
+	batchBeginPersistedPrepareXID   = 0x12
+	batchBeginUnprepareXID          = 0x13
+)
+
+// batchHeaderSize is the size of the sequence number and count preceding the records of a write batch.
+const batchHeaderSize = 12
+
+// replayBatch applies a write batch read from the write-ahead log with the given number to the memtables
+// of the column families which did not yet persist the log's writes in table files.
+func (v *version) replayBatch(batch []byte, logNumber uint64) error {
+	if len(batch) < batchHeaderSize {
+		return errors.Wrapf(ErrCorrupted, "write batch of %d bytes", len(batch))
+	}
+	seq := binary.LittleEndian.Uint64(batch)
+	count := binary.LittleEndian.Uint32(batch[8:])
+	if count > 0 && seq+uint64(count)-1 > v.lastSequence {
+		v.lastSequence = seq + uint64(count) - 1
+	}
+
+	d := &decoder{b: batch[batchHeaderSize:]}
+	for !d.empty() && d.err == nil {
+		recordType := d.byte()
+		var cfID uint32
+		switch recordType {
+		case batchColumnFamilyValue, batchColumnFamilyDeletion, batchColumnFamilySingleDeletion:
+			cfID = d.uvarint32()
+		}
+
+		switch recordType {
+		case batchValue, batchColumnFamilyValue:
+			key := d.lengthPrefixed()
+			value := d.lengthPrefixed()
+			if cf, has := v.columnFamilies[cfID]; has && logNumber >= cf.logNumber && d.err == nil {
+				cf.mem.put(key, value)
+			}
+		case batchDeletion, batchColumnFamilyDeletion, batchSingleDeletion, batchColumnFamilySingleDeletion:
+			key := d.lengthPrefixed()
+			if cf, has := v.columnFamilies[cfID]; has && logNumber >= cf.logNumber && d.err == nil {
+				cf.mem.delete(key)
+			}
+		case batchLogData, batchEndPrepareXID, batchCommitXID, batchRollbackXID:
+			d.lengthPrefixed()
+		case batchNoop, batchBeginPrepareXID, batchBeginPersistedPrepareXID, batchBeginUnprepareXID:
+		case batchMerge, batchColumnFamilyMerge:
+			return errors.Wrap(ErrUnsupported, "merge operands in the write-ahead log")
+		default:
+			return errors.Wrapf(ErrUnsupported, "write batch record type %d", recordType)
+		}
+	}
+	return errors.Wrap(d.err, "write batch")
+}
+
+// encodeBatch encodes the given writes into the given column families as a write batch,
+// whose writes are assigned the sequence numbers starting at seq.
+func encodeBatch(seq uint64, writes []write, cfs []*columnFamily) []byte {
+	b := make([]byte, batchHeaderSize, batchHeaderSize+len(writes)*64)
+	binary.LittleEndian.PutUint64(b, seq)
+	binary.LittleEndian.PutUint32(b[8:], uint32(len(writes)))
+	for i, w := range writes {
+		if cfs[i].id == 0 {
+			b = append(b, batchValue)
+		} else {
+			b = appendUvarint(append(b, batchColumnFamilyValue), uint64(cfs[i].id))
+		}
+		b = appendLengthPrefixed(appendLengthPrefixed(b, w.key), w.value)
+	}
+	return b
+}
+
+// walWriter appends write batches to a write-ahead log.
+type walWriter struct {
+	number uint64
+	f      *os.File
+	bw     *bufio.Writer
+	lw     *logWriter
+}
+
+func createWAL(name string, number uint64) (*walWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriterSize(f, logBlockSize)
+	return &walWriter{number: number, f: f, bw: bw, lw: newLogWriter(bw)}, nil
+}
+
+// write appends the given write batch and hands it to the operating system without syncing it,
+// as RocksDB does with its default write options.
+func (w *walWriter) write(batch []byte) error {
+	if err := w.lw.write(batch); err != nil {
+		return err
+	}
+	return w.bw.Flush()
+}
+
+func (w *walWriter) close() error {
+	err := w.bw.Flush()
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
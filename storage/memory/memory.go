@@ -0,0 +1,170 @@
+// Package memory implements an in-memory storage.Store, i.e. to run the tool without RocksDB in tests.
+package memory
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/iotaledger/iri-ls-sa-merger/storage"
+	"github.com/pkg/errors"
+)
+
+// Store is an in-memory storage.Store. It is safe for concurrent use.
+type Store struct {
+	mu             sync.RWMutex
+	columnFamilies map[string]map[string][]byte
+}
+
+// New creates a new empty Store with the given column families.
+func New(columnFamilies ...string) *Store {
+	s := &Store{columnFamilies: make(map[string]map[string][]byte, len(columnFamilies))}
+	s.addColumnFamilies(columnFamilies)
+	return s
+}
+
+func (s *Store) addColumnFamilies(columnFamilies []string) {
+	for _, cf := range columnFamilies {
+		if _, has := s.columnFamilies[cf]; !has {
+			s.columnFamilies[cf] = make(map[string][]byte)
+		}
+	}
+}
+
+func (s *Store) columnFamily(cf string) (map[string][]byte, error) {
+	entries, has := s.columnFamilies[cf]
+	if !has {
+		return nil, errors.Wrapf(storage.ErrUnknownColumnFamily, "column family %s", cf)
+	}
+	return entries, nil
+}
+
+// Get implements storage.Store.
+func (s *Store) Get(cf string, key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries, err := s.columnFamily(cf)
+	if err != nil {
+		return nil, err
+	}
+	value, has := entries[string(key)]
+	if !has {
+		return nil, nil
+	}
+	return append([]byte{}, value...), nil
+}
+
+// Put implements storage.Store.
+func (s *Store) Put(cf string, key []byte, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.columnFamily(cf)
+	if err != nil {
+		return err
+	}
+	entries[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+// ForEach implements storage.Store. The keys are collected when the iteration starts,
+// writes during the iteration do not affect which keys are passed.
+func (s *Store) ForEach(cf string, fn func(key []byte, value []byte) error) error {
+	s.mu.RLock()
+	entries, err := s.columnFamily(cf)
+	if err != nil {
+		s.mu.RUnlock()
+		return err
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	s.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s.mu.RLock()
+		value, has := entries[key]
+		s.mu.RUnlock()
+		if !has {
+			continue
+		}
+		if err := fn([]byte(key), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewBatch implements storage.Store. The options are ignored, as the store has no write-ahead log.
+func (s *Store) NewBatch(opts storage.WriteOptions) storage.Batch {
+	return &batch{store: s}
+}
+
+// Flush implements storage.Store, it has nothing to do.
+func (s *Store) Flush() error {
+	return nil
+}
+
+// Close implements storage.Store. The content of the store is kept.
+func (s *Store) Close() error {
+	return nil
+}
+
+type write struct {
+	cf    string
+	key   []byte
+	value []byte
+}
+
+type batch struct {
+	store  *Store
+	writes []write
+}
+
+func (b *batch) Put(cf string, key []byte, value []byte) {
+	b.writes = append(b.writes, write{cf: cf, key: append([]byte{}, key...), value: append([]byte{}, value...)})
+}
+
+func (b *batch) Count() int {
+	return len(b.writes)
+}
+
+func (b *batch) Write() error {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+	// the batch is applied entirely or not at all
+	for _, w := range b.writes {
+		if _, err := b.store.columnFamily(w.cf); err != nil {
+			return err
+		}
+	}
+	for _, w := range b.writes {
+		b.store.columnFamilies[w.cf][string(w.key)] = w.value
+	}
+	b.writes = nil
+	return nil
+}
+
+func (b *batch) Close() {
+	b.writes = nil
+}
+
+// Opener returns a storage.Opener which keeps the stores it opened by their folder,
+// so reopening a folder yields the content written before, as with an on-disk store.
+func Opener() storage.Opener {
+	var mu sync.Mutex
+	stores := make(map[string]*Store)
+	return func(dir string, columnFamilies []string) (storage.Store, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		s, has := stores[dir]
+		if !has {
+			s = New()
+			stores[dir] = s
+		}
+		s.mu.Lock()
+		s.addColumnFamilies(columnFamilies)
+		s.mu.Unlock()
+		return s, nil
+	}
+}
@@ -0,0 +1,372 @@
+// Package rocksdb implements a storage.Store backed by RocksDB, the storage engine used by IRI.
+package rocksdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/iotaledger/iri-ls-sa-merger/storage"
+	"github.com/pkg/errors"
+	"github.com/tecbot/gorocksdb"
+)
+
+const bloomFilterBitsPerKey = 10
+const blockSizeDeviation = 10
+const blockRestartInterval = 16
+const blockCacheSize = 1000 * 1024
+const cacheNumShardBits = 2
+
+// ingestFileSize is the amount of bytes of keys and values after which a SortedWriter starts a new table file.
+const ingestFileSize = 64 * 1024 * 1024
+
+// Store is a storage.Store backed by a RocksDB database.
+type Store struct {
+	dir            string
+	db             *gorocksdb.DB
+	columnFamilies map[string]*gorocksdb.ColumnFamilyHandle
+	ro             *gorocksdb.ReadOptions
+	wo             *gorocksdb.WriteOptions
+	// unpersisted is set to 1 once writes bypassed the write-ahead log and reset by Flush.
+	unpersisted int32
+	readOnly    bool
+	// logDir is the temporary folder of the info log of a database opened read-only.
+	logDir string
+}
+
+// Open opens the RocksDB database in the given folder with the given column families
+// and creates it if it does not exist. It implements storage.Opener.
+func Open(dir string, columnFamilies []string) (storage.Store, error) {
+	return open(dir, columnFamilies, false)
+}
+
+// OpenReadOnly opens the existing RocksDB database in the given folder with the given column families for reading.
+// No file of the database is created, written or removed. It implements storage.Opener.
+func OpenReadOnly(dir string, columnFamilies []string) (storage.Store, error) {
+	return open(dir, columnFamilies, true)
+}
+
+func open(dir string, columnFamilies []string, readOnly bool) (storage.Store, error) {
+	cfOpts := make([]*gorocksdb.Options, len(columnFamilies))
+	cfOpt := gorocksdb.NewDefaultOptions()
+	for i := range cfOpts {
+		cfOpts[i] = cfOpt
+	}
+
+	opts := defaultOpts()
+	var db *gorocksdb.DB
+	var cfs []*gorocksdb.ColumnFamilyHandle
+	var logDir string
+	var err error
+	if readOnly {
+		// RocksDB creates the folder of the database before it finds out that there is none
+		if _, err := os.Stat(filepath.Join(dir, "CURRENT")); err != nil {
+			return nil, errors.Wrapf(err, "database %s does not exist", dir)
+		}
+		// RocksDB writes its info log even if the database is opened read-only, it is kept out of the database folder
+		if logDir, err = ioutil.TempDir("", "rocksdb-log-"); err != nil {
+			return nil, err
+		}
+		opts.SetDbLogDir(logDir)
+		opts.SetCreateIfMissing(false)
+		db, cfs, err = gorocksdb.OpenDbForReadOnlyColumnFamilies(opts, dir, columnFamilies, cfOpts, false)
+	} else {
+		db, cfs, err = gorocksdb.OpenDbColumnFamilies(opts, dir, columnFamilies, cfOpts)
+	}
+	if err != nil {
+		if logDir != "" {
+			os.RemoveAll(logDir)
+		}
+		return nil, err
+	}
+
+	s := &Store{
+		dir:            dir,
+		readOnly:       readOnly,
+		logDir:         logDir,
+		db:             db,
+		columnFamilies: make(map[string]*gorocksdb.ColumnFamilyHandle, len(columnFamilies)),
+		ro:             gorocksdb.NewDefaultReadOptions(),
+		wo:             gorocksdb.NewDefaultWriteOptions(),
+	}
+	for i, cf := range columnFamilies {
+		s.columnFamilies[cf] = cfs[i]
+	}
+	return s, nil
+}
+
+func defaultOpts() *gorocksdb.Options {
+	// db opts
+	opts := gorocksdb.NewDefaultOptions()
+	opts.SetCreateIfMissing(true)
+	opts.SetCreateIfMissingColumnFamilies(true)
+	opts.SetMaxOpenFiles(10000)
+	opts.SetMaxBackgroundCompactions(1)
+	opts.SetMaxLogFileSize(1024 * 1024)
+	opts.SetMaxManifestFileSize(1024 * 1024)
+
+	// block based table opts
+	opts.SetBlockBasedTableFactory(defaultBlockBasedTableOpts())
+	opts.SetTableCacheNumshardbits(cacheNumShardBits)
+	return opts
+}
+
+// defaultBlockBasedTableOpts returns the block based table options of the database,
+// the table files written for an ingestion use them as well.
+func defaultBlockBasedTableOpts() *gorocksdb.BlockBasedTableOptions {
+	bbto := gorocksdb.NewDefaultBlockBasedTableOptions()
+	bloomFilter := gorocksdb.NewBloomFilter(bloomFilterBitsPerKey)
+	bbto.SetFilterPolicy(bloomFilter)
+	bbto.SetBlockSizeDeviation(10)
+	bbto.SetBlockRestartInterval(blockRestartInterval)
+	bbto.SetBlockSizeDeviation(blockSizeDeviation)
+	bbto.SetBlockCache(gorocksdb.NewLRUCache(blockCacheSize))
+	return bbto
+}
+
+func (s *Store) columnFamily(cf string) (*gorocksdb.ColumnFamilyHandle, error) {
+	handle, has := s.columnFamilies[cf]
+	if !has {
+		return nil, errors.Wrapf(storage.ErrUnknownColumnFamily, "column family %s", cf)
+	}
+	return handle, nil
+}
+
+// Get implements storage.Store.
+func (s *Store) Get(cf string, key []byte) ([]byte, error) {
+	handle, err := s.columnFamily(cf)
+	if err != nil {
+		return nil, err
+	}
+	value, err := s.db.GetCF(s.ro, handle, key)
+	if err != nil {
+		return nil, err
+	}
+	defer value.Free()
+	if value.Size() == 0 {
+		return nil, nil
+	}
+	return append([]byte{}, value.Data()...), nil
+}
+
+// Put implements storage.Store.
+func (s *Store) Put(cf string, key []byte, value []byte) error {
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
+	handle, err := s.columnFamily(cf)
+	if err != nil {
+		return err
+	}
+	return s.db.PutCF(s.wo, handle, key, value)
+}
+
+// ForEach implements storage.Store.
+func (s *Store) ForEach(cf string, fn func(key []byte, value []byte) error) error {
+	handle, err := s.columnFamily(cf)
+	if err != nil {
+		return err
+	}
+
+	ro := gorocksdb.NewDefaultReadOptions()
+	defer ro.Destroy()
+	// bulk scans should not evict hot data from the block cache
+	ro.SetFillCache(false)
+
+	it := s.db.NewIteratorCF(ro, handle)
+	defer it.Close()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		key := it.Key()
+		value := it.Value()
+		err := fn(key.Data(), value.Data())
+		key.Free()
+		value.Free()
+		if err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// NewBatch implements storage.Store.
+func (s *Store) NewBatch(opts storage.WriteOptions) storage.Batch {
+	b := &batch{store: s, wb: gorocksdb.NewWriteBatch(), wo: s.wo}
+	if opts.DisableWAL {
+		b.wo = gorocksdb.NewDefaultWriteOptions()
+		b.wo.DisableWAL(true)
+		b.disableWAL = true
+	}
+	return b
+}
+
+// Flush implements storage.Store. The C API of RocksDB only flushes the default column family,
+// the writes of the other column families which bypassed the write-ahead log are flushed when the store is closed,
+// as RocksDB flushes all memtables on shutdown once a write bypassed the write-ahead log.
+func (s *Store) Flush() error {
+	if !atomic.CompareAndSwapInt32(&s.unpersisted, 1, 0) {
+		return nil
+	}
+	fo := gorocksdb.NewDefaultFlushOptions()
+	defer fo.Destroy()
+	fo.SetWait(true)
+	return s.db.Flush(fo)
+}
+
+// Close implements storage.Store.
+func (s *Store) Close() error {
+	s.ro.Destroy()
+	s.wo.Destroy()
+	s.db.Close()
+	if s.logDir != "" {
+		return os.RemoveAll(s.logDir)
+	}
+	return nil
+}
+
+type batch struct {
+	store      *Store
+	wb         *gorocksdb.WriteBatch
+	wo         *gorocksdb.WriteOptions
+	disableWAL bool
+	// err is the first error of a write added to the batch, it is returned by Write.
+	err error
+}
+
+func (b *batch) Put(cf string, key []byte, value []byte) {
+	handle, err := b.store.columnFamily(cf)
+	if err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return
+	}
+	b.wb.PutCF(handle, key, value)
+}
+
+func (b *batch) Count() int {
+	return b.wb.Count()
+}
+
+func (b *batch) Write() error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.store.readOnly {
+		return storage.ErrReadOnly
+	}
+	if b.disableWAL {
+		atomic.StoreInt32(&b.store.unpersisted, 1)
+	}
+	if err := b.store.db.Write(b.wo, b.wb); err != nil {
+		return err
+	}
+	b.wb.Clear()
+	return nil
+}
+
+func (b *batch) Close() {
+	b.wb.Destroy()
+	if b.disableWAL {
+		b.wo.Destroy()
+	}
+}
+
+// NewSortedWriter implements storage.BulkLoader. The pairs are written into table files within a temporary folder
+// next to the database, which are moved into the database by RocksDB's external file ingestion once Finish is called.
+func (s *Store) NewSortedWriter(cf string) (storage.SortedWriter, error) {
+	if s.readOnly {
+		return nil, storage.ErrReadOnly
+	}
+	handle, err := s.columnFamily(cf)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(filepath.Dir(filepath.Clean(s.dir)), filepath.Base(s.dir)+".ingest-")
+	if err != nil {
+		return nil, err
+	}
+	// the table files are built like the ones written by the database itself, e.g. including the bloom filter
+	opts := gorocksdb.NewDefaultOptions()
+	opts.SetBlockBasedTableFactory(defaultBlockBasedTableOpts())
+	return &sortedWriter{
+		store:   s,
+		handle:  handle,
+		dir:     dir,
+		envOpts: gorocksdb.NewDefaultEnvOptions(),
+		opts:    opts,
+	}, nil
+}
+
+type sortedWriter struct {
+	store   *Store
+	handle  *gorocksdb.ColumnFamilyHandle
+	dir     string
+	envOpts *gorocksdb.EnvOptions
+	opts    *gorocksdb.Options
+
+	// w writes the current table file, nil if none is open.
+	w       *gorocksdb.SSTFileWriter
+	size    int
+	files   []string
+	lastKey []byte
+}
+
+func (w *sortedWriter) Put(key []byte, value []byte) error {
+	if w.lastKey != nil && bytes.Compare(w.lastKey, key) >= 0 {
+		return errors.Wrapf(storage.ErrNotSorted, "key %x after %x", key, w.lastKey)
+	}
+	if w.w == nil {
+		file := filepath.Join(w.dir, fmt.Sprintf("%06d.sst", len(w.files)+1))
+		w.w = gorocksdb.NewSSTFileWriter(w.envOpts, w.opts)
+		if err := w.w.Open(file); err != nil {
+			return err
+		}
+		w.files = append(w.files, file)
+	}
+	if err := w.w.Add(key, value); err != nil {
+		return err
+	}
+	w.lastKey = append(w.lastKey[:0], key...)
+	w.size += len(key) + len(value)
+	if w.size >= ingestFileSize {
+		return w.finishFile()
+	}
+	return nil
+}
+
+func (w *sortedWriter) finishFile() error {
+	if w.w == nil {
+		return nil
+	}
+	err := w.w.Finish()
+	w.w.Destroy()
+	w.w = nil
+	w.size = 0
+	return err
+}
+
+func (w *sortedWriter) Finish() error {
+	if err := w.finishFile(); err != nil {
+		return err
+	}
+	if len(w.files) == 0 {
+		return nil
+	}
+	opts := gorocksdb.NewDefaultIngestExternalFileOptions()
+	defer opts.Destroy()
+	opts.SetMoveFiles(true)
+	return w.store.db.IngestExternalFileCF(w.handle, w.files, opts)
+}
+
+func (w *sortedWriter) Close() {
+	if w.w != nil {
+		w.w.Destroy()
+		w.w = nil
+	}
+	w.envOpts.Destroy()
+	w.opts.Destroy()
+	os.RemoveAll(w.dir)
+}
@@ -0,0 +1,119 @@
+package rocksdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/iotaledger/iri-ls-sa-merger/storage"
+)
+
+// ingestTestRun writes the given keys of a run with the name of the run as their value via a SortedWriter.
+func ingestTestRun(t *testing.T, s storage.Store, run string, keys []string) {
+	t.Helper()
+	w, err := s.(storage.BulkLoader).NewSortedWriter(storage.ColumnFamilySpentAddresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for _, key := range keys {
+		if err := w.Put([]byte(key), []byte(run)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testKeys returns the keys from start up to end in steps of the given size.
+func testKeys(start int, end int, step int) []string {
+	var keys []string
+	for i := start; i < end; i += step {
+		keys = append(keys, fmt.Sprintf("key-%04d", i))
+	}
+	return keys
+}
+
+// expectTestRuns fails the test if the spent-addresses column family does not consist of the given keys and values.
+func expectTestRuns(t *testing.T, s storage.Store, expected map[string]string) {
+	t.Helper()
+	var keys []string
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	i := 0
+	if err := s.ForEach(storage.ColumnFamilySpentAddresses, func(key []byte, value []byte) error {
+		if i >= len(keys) || string(key) != keys[i] || string(value) != expected[keys[i]] {
+			return fmt.Errorf("unexpected key %s with value %s at position %d", key, value, i)
+		}
+		i++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(keys) {
+		t.Fatalf("expected %d keys, got %d", len(keys), i)
+	}
+
+	for _, key := range keys {
+		value, err := s.Get(storage.ColumnFamilySpentAddresses, []byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(value) != expected[key] {
+			t.Fatalf("expected the value %s of key %s, got %s", expected[key], key, value)
+		}
+	}
+	for _, key := range []string{"key-0101", "key-0300"} {
+		if value, err := s.Get(storage.ColumnFamilySpentAddresses, []byte(key)); err != nil || value != nil {
+			t.Fatalf("expected no value of the missing key %s, got %q, %v", key, value, err)
+		}
+	}
+}
+
+func TestSortedWriterIngestion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rocksdb-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, storage.LocalSnapshotsDBColumnFamilies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first two runs do not overlap, the last two overlap each other and the first two,
+	// so that the values of the later runs replace the ones of the earlier runs
+	runs := []struct {
+		name string
+		keys []string
+	}{
+		{"first", testKeys(0, 100, 1)},
+		{"second", testKeys(200, 300, 1)},
+		{"third", testKeys(50, 250, 2)},
+		{"fourth", testKeys(40, 260, 5)},
+	}
+	expected := make(map[string]string)
+	for _, run := range runs {
+		ingestTestRun(t, s, run.name, run.keys)
+		for _, key := range run.keys {
+			expected[key] = run.name
+		}
+	}
+	expectTestRuns(t, s, expected)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenReadOnly(dir, storage.LocalSnapshotsDBColumnFamilies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	expectTestRuns(t, s, expected)
+}
@@ -0,0 +1,87 @@
+// Package storage defines the key-value store the databases of IRI are accessed through,
+// so that the tool does not depend on a specific storage engine.
+package storage
+
+import (
+	"github.com/pkg/errors"
+)
+
+// the column families used by IRI's databases.
+const (
+	ColumnFamilyDefault        = "default"
+	ColumnFamilySpentAddresses = "spent-addresses"
+	ColumnFamilyLocalSnapshots = "localsnapshots"
+)
+
+var (
+	// SpentAddressesDBColumnFamilies are the column families of a spent-addresses-db.
+	SpentAddressesDBColumnFamilies = []string{ColumnFamilyDefault, ColumnFamilySpentAddresses}
+	// LocalSnapshotsDBColumnFamilies are the column families of a localsnapshots-db.
+	LocalSnapshotsDBColumnFamilies = []string{ColumnFamilyDefault, ColumnFamilySpentAddresses, ColumnFamilyLocalSnapshots}
+)
+
+var (
+	// ErrUnknownColumnFamily is returned when a column family is accessed which the store was not opened with.
+	ErrUnknownColumnFamily = errors.New("unknown column family")
+	// ErrNotSorted is returned when a key is passed to a SortedWriter which is not greater than its predecessor.
+	ErrNotSorted = errors.New("keys not in strictly ascending order")
+	// ErrReadOnly is returned when a store which was opened read-only is written to.
+	ErrReadOnly = errors.New("store opened read-only")
+)
+
+// Store is a key-value store whose keys are organized in column families.
+type Store interface {
+	// Get returns a copy of the value of the given key within the given column family or nil if the key does not exist.
+	Get(cf string, key []byte) ([]byte, error)
+	// Put sets the value of the given key within the given column family.
+	Put(cf string, key []byte, value []byte) error
+	// ForEach passes every key and its value of the given column family in ascending key order to the given function.
+	// The passed slices are only valid for the duration of the call. Returning an error aborts the iteration.
+	ForEach(cf string, fn func(key []byte, value []byte) error) error
+	// NewBatch creates a batch of writes which are applied together with the given options.
+	NewBatch(opts WriteOptions) Batch
+	// Flush persists the writes which bypassed the write-ahead log, see WriteOptions.DisableWAL.
+	// Implementations which can not flush every column family on their own persist the remaining writes on Close.
+	Flush() error
+	// Close closes the store, it must not be used afterwards.
+	Close() error
+}
+
+// WriteOptions define how the writes of a batch are applied.
+type WriteOptions struct {
+	// DisableWAL defines whether the writes skip the write-ahead log. They are only persisted
+	// once Flush is called or the store is closed, therefore they may be lost if the process crashes before.
+	DisableWAL bool
+}
+
+// Batch collects writes which are applied to a store together.
+type Batch interface {
+	// Put adds setting the value of the given key within the given column family to the batch.
+	Put(cf string, key []byte, value []byte)
+	// Count returns the amount of writes within the batch.
+	Count() int
+	// Write applies the writes of the batch to the store and clears the batch.
+	Write() error
+	// Close releases the resources of the batch, writes which were not applied are discarded.
+	Close()
+}
+
+// SortedWriter writes key-value pairs which are passed in strictly ascending key order.
+type SortedWriter interface {
+	// Put adds the given pair, its key must be greater than the key of the previous pair.
+	Put(key []byte, value []byte) error
+	// Finish makes the added pairs visible in the store, the writer must not be used afterwards except for Close.
+	Finish() error
+	// Close releases the resources of the writer, pairs which were not finished are discarded.
+	Close()
+}
+
+// BulkLoader is implemented by stores which can load sorted data as a whole, bypassing the path of single writes.
+type BulkLoader interface {
+	// NewSortedWriter creates a SortedWriter which loads the pairs into the given column family.
+	NewSortedWriter(cf string) (SortedWriter, error)
+}
+
+// Opener opens the store in the given folder with the given column families. Openers of writable stores create it
+// if it does not exist, read-only openers neither create nor modify any file of the store.
+type Opener func(dir string, columnFamilies []string) (Store, error)
@@ -0,0 +1,15 @@
+//go:build !purego
+// +build !purego
+
+package main
+
+import (
+	"github.com/iotaledger/iri-ls-sa-merger/storage"
+	"github.com/iotaledger/iri-ls-sa-merger/storage/rocksdb"
+)
+
+// openStore opens the databases of IRI.
+var openStore storage.Opener = rocksdb.Open
+
+// openStoreReadOnly opens the databases of IRI which are only read, without modifying any of their files.
+var openStoreReadOnly storage.Opener = rocksdb.OpenReadOnly